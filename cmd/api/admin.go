@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// impersonationTTL is kept short as the token grants full access to somebody
+// else's account.
+const impersonationTTL = 15 * time.Minute
+
+//	@Summary		Unlock User
+//	@Description	Lift a lockout caused by failed logins. Lockouts are kept per instance, so this only lifts it on the instance handling the request
+//	@Security		ApiKeyAuth
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/admin/users/{id}/unlock [post]
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.emailLimiter.reset(strings.ToLower(user.Email))
+
+	app.logger.PrintInfo("account unlocked", map[string]string{
+		"user_id":  strconv.FormatInt(user.ID, 10),
+		"admin_id": strconv.FormatInt(admin.ID, 10),
+	})
+	app.audit(r, data.AuditUserUnlock, data.AuditEntityUser, user.ID, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		List Users
+//	@Description	All user accounts, filtered by role, email substring and registration date
+//	@Security		ApiKeyAuth
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			role			query		string	false	"Admin, Client or Customer"
+//	@Param			email			query		string	false	"Part of the email address"
+//	@Param			created_from	query		string	false	"Registered on or after (YYYY-MM-DD)"
+//	@Param			created_to		query		string	false	"Registered before (YYYY-MM-DD)"
+//	@Param			page			query		int		false	"page"
+//	@Param			page_size		query		int		false	"Page size"
+//	@Param			sort			query		string	false	"sort"
+//	@Success		200				{object}	[]data.User
+//	@Failure		401				{object}	Error
+//	@Failure		403				{object}	Error
+//	@Failure		422				{object}	Error
+//	@Failure		500				{object}	Error
+//	@Router			/admin/users [get]
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	input := data.InputListUsers{}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Role = app.readString(qs, "role", "")
+	input.Email = app.readString(qs, "email", "")
+	input.CreatedFrom = app.readDate(qs, "created_from", v)
+	input.CreatedTo = app.readDate(qs, "created_to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "email", "created_at", "-id", "-email", "-created_at"}
+
+	if input.Role != "" {
+		v.Check(validator.In(input.Role, data.Roles_name[0], data.Roles_name[1], data.Roles_name[2]), "role", "must be Admin, Client or Customer")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Update User
+//	@Description	Change the role of a user or suspend and reinstate the account
+//	@Security		ApiKeyAuth
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"User ID"
+//	@Param			input	body		data.InputAdminUpdateUser	true	"Input"
+//	@Success		200		{object}	data.User
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/admin/users/{id} [patch]
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	input := &data.InputAdminUpdateUser{}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != user.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	before := *user
+
+	v := validator.New()
+
+	// Keeps the last admin from locking everybody out by accident.
+	v.Check(user.ID != admin.ID, "id", "you can't change your own account")
+
+	roleChanged := input.Role != nil && *input.Role != user.Role
+	if input.Role != nil {
+		v.Check(validator.In(*input.Role, data.Roles_name[0], data.Roles_name[1], data.Roles_name[2]), "role", "must be Admin, Client or Customer")
+		user.Role = *input.Role
+	}
+
+	suspended := input.Suspended != nil && *input.Suspended && !user.Suspended
+	if input.Suspended != nil {
+		user.Suspended = *input.Suspended
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if roleChanged {
+		err = app.models.Users.UpdateWithPermissions(user, data.RolePermissions[user.Role])
+	} else {
+		err = app.models.Users.Update(user)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A suspended user is logged out everywhere. Opaque tokens would be turned
+	// down by authenticate anyway, but access tokens in JWT mode would not.
+	// The same goes for a new role, which JWTs would otherwise keep carrying
+	// the old one of until they expire.
+	if suspended || roleChanged {
+		err = app.revokeAllTokens(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.logger.PrintInfo("user account updated", map[string]string{
+		"user_id":   strconv.FormatInt(user.ID, 10),
+		"admin_id":  strconv.FormatInt(admin.ID, 10),
+		"role":      user.Role,
+		"suspended": strconv.FormatBool(user.Suspended),
+	})
+	app.audit(r, data.AuditUserUpdate, data.AuditEntityUser, user.ID, before, user)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Impersonate User
+//	@Description	Issue a short-lived authentication token for acting as another user, for example to reproduce a support issue
+//	@Security		ApiKeyAuth
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		201	{object}	data.Token
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		422	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/admin/users/{id}/impersonate [post]
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+	admin := app.contextGetUser(r)
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(user.ID != admin.ID, "id", "you can't impersonate yourself")
+	v.Check(!permissions.Include(data.PermissionUsersManage), "id", "other administrators can't be impersonated")
+	v.Check(!user.Suspended, "id", "suspended users can't be impersonated")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The token is always opaque, also in JWT mode, so it shows up in the
+	// user's sessions and has no refresh token to outlive impersonationTTL.
+	token, err := app.models.Tokens.NewForFamily(user.ID, impersonationTTL, data.ScopeAuthentication, nil, app.userAgent(r), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("user impersonated", map[string]string{
+		"user_id":  strconv.FormatInt(user.ID, 10),
+		"admin_id": strconv.FormatInt(admin.ID, 10),
+		"ip":       app.clientIP(r),
+		"expiry":   token.Expiry.Format(time.RFC3339),
+	})
+	app.audit(r, data.AuditUserImpersonate, data.AuditEntityUser, user.ID, nil, map[string]time.Time{"expiry": token.Expiry})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
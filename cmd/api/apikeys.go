@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+)
+
+//	@Summary		Create API Key
+//	@Description	Create a long-lived API key for an integration. The key is sent in the X-API-Key header and is only shown in this response
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputCreateAPIKey	true	"Name, scopes and optional expiry"
+//	@Success		201		{object}	data.APIKey
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputCreateAPIKey
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	key := &data.APIKey{
+		UserID: user.ID,
+		Name:   input.Name,
+		Scopes: input.Scopes,
+		Expiry: input.Expiry,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, scope := range key.Scopes {
+		v.Check(permissions.Include(scope), "scopes", "must only contain permissions your account has")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditAPIKeyCreate, data.AuditEntityAPIKey, key.ID, nil, map[string]interface{}{
+		"name":   key.Name,
+		"prefix": key.Prefix,
+		"scopes": key.Scopes,
+		"expiry": key.Expiry,
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		List API Keys
+//	@Description	API keys of the authenticated user, without the keys themselves
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	[]data.APIKey
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/users/me/api-keys [get]
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Revoke API Key
+//	@Description	Revoke an API key of the authenticated user
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/users/me/api-keys/{id} [delete]
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditAPIKeyRevoke, data.AuditEntityAPIKey, id, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// readAttributeFilters reads the attr.<code> and attr.<code>[<operator>]
+// query string parameters of a product listing. Comma separated values of an
+// equality filter match any of them.
+func (app *application) readAttributeFilters(qs url.Values, v *validator.Validator) []data.AttributeFilter {
+	filters := []data.AttributeFilter{}
+
+	for key, values := range qs {
+		if !strings.HasPrefix(key, "attr.") {
+			continue
+		}
+
+		filter := data.AttributeFilter{Code: strings.TrimPrefix(key, "attr."), Operator: "eq"}
+
+		if code, operator, ok := strings.Cut(filter.Code, "["); ok && strings.HasSuffix(operator, "]") {
+			filter.Code = code
+			filter.Operator = strings.TrimSuffix(operator, "]")
+		}
+
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					filter.Values = append(filter.Values, part)
+				}
+			}
+		}
+
+		data.ValidateAttributeFilter(v, filter)
+		filters = append(filters, filter)
+	}
+
+	v.Check(len(filters) <= 10, "attr", "must not contain more than 10 attribute filters")
+
+	return filters
+}
+
+// readCategoryParam loads the category of a /v1/categories/:id/... route,
+// writing a 404 or 500 and returning false when that fails.
+func (app *application) readCategoryParam(w http.ResponseWriter, r *http.Request) (*data.Category, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return category, true
+}
+
+//	@Summary		List Category Attributes
+//	@Description	Attributes products of a category can have, including those inherited from parent categories
+//	@Tags			Category
+//	@Produce		json
+//	@Param			id	path		int	true	"Category ID"
+//	@Success		200	{object}	[]data.Attribute
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/categories/{id}/attributes [get]
+func (app *application) listCategoryAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.readCategoryParam(w, r)
+	if !ok {
+		return
+	}
+
+	attributes, err := app.models.Attributes.GetAllForCategory(category.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": attributes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Create Category Attribute
+//	@Description	Define an attribute for the products of a category and its subcategories
+//	@Security		ApiKeyAuth
+//	@Tags			Category
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Category ID"
+//	@Param			input	body		data.InputCreateAttribute	true	"Input"
+//	@Success		201		{object}	data.Attribute
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/categories/{id}/attributes [post]
+func (app *application) createCategoryAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.readCategoryParam(w, r)
+	if !ok {
+		return
+	}
+
+	input := &data.InputCreateAttribute{}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attribute := &data.Attribute{
+		CategoryID: category.ID,
+		Code:       input.Code,
+		Name:       input.Name,
+		Type:       input.Type,
+		Options:    input.Options,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAttribute(v, attribute); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Attributes.Insert(attribute)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAttribute):
+			v.AddError("code", "the category already has an attribute with this code")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/categories/%d/attributes", category.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"attribute": attribute}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Delete Category Attribute
+//	@Description	Delete an attribute of a category together with the values products have for it
+//	@Security		ApiKeyAuth
+//	@Tags			Category
+//	@Produce		json
+//	@Param			id				path		int	true	"Category ID"
+//	@Param			attribute_id	path		int	true	"Attribute ID"
+//	@Success		200				{object}	string
+//	@Failure		401				{object}	Error
+//	@Failure		403				{object}	Error
+//	@Failure		404				{object}	Error
+//	@Failure		500				{object}	Error
+//	@Router			/categories/{id}/attributes/{attribute_id} [delete]
+func (app *application) deleteCategoryAttributeHandler(w http.ResponseWriter, r *http.Request) {
+	categoryID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	id, err := app.readNamedIDParam(r, "attribute_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Attributes.Delete(id, categoryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "attribute successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Set Product Attributes
+//	@Description	Replace the attribute values of a product, given as an object keyed by attribute code. Only attributes of the product's category can be set
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int		true	"Product ID"
+//	@Param			input	body		object	true	"Values by attribute code"
+//	@Success		200		{object}	object
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/products/{id}/attributes [put]
+func (app *application) setProductAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	var input map[string]interface{}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	attributes, err := app.models.Attributes.GetAllForCategory(int64(product.Category))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	byCode := make(map[string]*data.Attribute, len(attributes))
+	for _, attribute := range attributes {
+		byCode[attribute.Code] = attribute
+	}
+
+	v := validator.New()
+	values := make([]data.AttributeValue, 0, len(input))
+
+	for code, raw := range input {
+		attribute, ok := byCode[code]
+		if !ok {
+			v.AddError(code, "is not an attribute of the product's category")
+			continue
+		}
+
+		value, ok := attribute.Value(raw)
+		if !ok {
+			v.AddError(code, fmt.Sprintf("must be a valid %s value", attribute.Type))
+			continue
+		}
+
+		values = append(values, value)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Attributes.SetForProduct(product.ID, values)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	product.Attributes, err = app.models.Attributes.GetForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": product.Attributes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
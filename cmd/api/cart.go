@@ -1,22 +1,19 @@
 package main
 
 import (
+	"errors"
 	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
 	"net/http"
 )
 
-type CartReq struct {
-	ProductID int `json:"product_id"`
-	Quantity  int `json:"quantity"`
-}
-
 //	@Summary		Create Cart
-//	@Description	Creat Cart for Shop
+//	@Description	Creat Cart for Shop. Takes a variant_id, or a product_id for products with a single variant
 //	@Security		ApiKeyAuth
 //	@Tags			Cart
 //	@Accept			json
 //	@Produce		json
-//	@Param			inout	body		CartReq	true	"input"
+//	@Param			inout	body		data.CartReq	true	"input"
 //	@Success		200		{object}	data.Cart
 //	@Failure		422		{object}	Error
 //	@Failure		404		{object}	Error
@@ -31,15 +28,41 @@ func (app *application) CreateCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := app.contextGetUser(r)
-	product, err := app.models.Products.Get(int64(input.ProductID))
+	v := validator.New()
+
+	variant, err := app.cartVariant(v, input)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	v.Check(input.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(input.Quantity <= variant.Stock, "quantity", "must not be more than the stock of the variant")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	product, err := app.models.Products.Get(variant.ProductID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "must be an existing product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 	cart := &data.Cart{
 		User:     *user,
 		Product:  *product,
+		Variant:  *variant,
 		Quantity: input.Quantity,
 	}
 
@@ -54,3 +77,45 @@ func (app *application) CreateCart(w http.ResponseWriter, r *http.Request) {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// cartVariant finds the variant a cart is for. A product ID alone only does for
+// products with a single variant.
+func (app *application) cartVariant(v *validator.Validator, input *data.CartReq) (*data.Variant, error) {
+	if input.VariantID != 0 {
+		variant, err := app.models.Variants.Get(input.VariantID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("variant_id", "must be an existing variant")
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if input.ProductID != 0 && int64(input.ProductID) != variant.ProductID {
+			v.AddError("variant_id", "must be a variant of the product")
+			return nil, nil
+		}
+		return variant, nil
+	}
+
+	if input.ProductID == 0 {
+		v.AddError("variant_id", "must be provided")
+		return nil, nil
+	}
+
+	variants, err := app.models.Variants.GetAllForProduct(int64(input.ProductID))
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(variants) {
+	case 0:
+		v.AddError("product_id", "must be an existing product")
+		return nil, nil
+	case 1:
+		return variants[0], nil
+	default:
+		v.AddError("variant_id", "must be provided for products with several variants")
+		return nil, nil
+	}
+}
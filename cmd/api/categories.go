@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+)
+
+// checkCategory adds a validation error when a product's category doesn't
+// exist. Zero and negative IDs are left to ValidateProduct.
+func (app *application) checkCategory(v *validator.Validator, id int32) error {
+	if id <= 0 {
+		return nil
+	}
+
+	exists, err := app.models.Categories.Exists(int64(id))
+	if err != nil {
+		return err
+	}
+
+	v.Check(exists, "category", "must be an existing category")
+	return nil
+}
+
+// checkParentCategory adds a validation error when the parent of the category
+// doesn't exist or lies in the category's own subtree, which would make a cycle.
+func (app *application) checkParentCategory(v *validator.Validator, category *data.Category) error {
+	if category.ParentID == nil || *category.ParentID <= 0 || *category.ParentID == category.ID {
+		return nil
+	}
+
+	exists, err := app.models.Categories.Exists(*category.ParentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		v.AddError("parent_id", "must be an existing category")
+		return nil
+	}
+
+	if category.ID == 0 {
+		return nil
+	}
+
+	descendant, err := app.models.Categories.IsDescendant(*category.ParentID, category.ID)
+	if err != nil {
+		return err
+	}
+
+	v.Check(!descendant, "parent_id", "must not be a subcategory of the category")
+	return nil
+}
+
+//	@Summary		List Categories
+//	@Description	The category tree, subcategories nested under their parents
+//	@Tags			Category
+//	@Produce		json
+//	@Success		200	{object}	[]data.Category
+//	@Failure		500	{object}	Error
+//	@Router			/categories [get]
+func (app *application) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Categories.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": data.BuildCategoryTree(categories)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Show Category
+//	@Description	Get a category by ID
+//	@Tags			Category
+//	@Produce		json
+//	@Param			id	path		int	true	"Category ID"
+//	@Success		200	{object}	data.Category
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/categories/{id} [get]
+func (app *application) showCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Create Category
+//	@Description	Create a category, optionally under a parent. The slug is derived from the title when left out, Cyrillic transliterated, or made of the ID if the title has nothing to derive it from
+//	@Security		ApiKeyAuth
+//	@Tags			Category
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputCreateCategory	true	"Input"
+//	@Success		201		{object}	data.Category
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/categories [post]
+func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	input := &data.InputCreateCategory{}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	category := &data.Category{
+		ParentID: input.ParentID,
+		Title:    input.Title,
+		Slug:     input.Slug,
+	}
+	if category.Slug == "" {
+		category.Slug = data.Slugify(category.Title)
+	}
+
+	v := validator.New()
+
+	data.ValidateCategory(v, category)
+	if err := app.checkParentCategory(v, category); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Insert(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/categories/%d", category.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"category": category}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Update Category
+//	@Description	Rename a category, change its slug or move it under another parent. A parent_id of 0 moves it to the top level
+//	@Security		ApiKeyAuth
+//	@Tags			Category
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Category ID"
+//	@Param			input	body		data.InputUpdateCategory	true	"Input"
+//	@Success		200		{object}	data.Category
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/categories/{id} [patch]
+func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	category, err := app.models.Categories.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	input := &data.InputUpdateCategory{}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != category.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.ParentID != nil {
+		if *input.ParentID == 0 {
+			category.ParentID = nil
+		} else {
+			category.ParentID = input.ParentID
+		}
+	}
+
+	if input.Title != nil {
+		category.Title = *input.Title
+	}
+
+	if input.Slug != nil {
+		category.Slug = *input.Slug
+	}
+
+	v := validator.New()
+
+	data.ValidateCategory(v, category)
+	if err := app.checkParentCategory(v, category); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Categories.Update(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Delete Category
+//	@Description	Delete a category without subcategories or products
+//	@Security		ApiKeyAuth
+//	@Tags			Category
+//	@Produce		json
+//	@Param			id	path		int	true	"Category ID"
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		409	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/categories/{id} [delete]
+func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Categories.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCategoryInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the category still has subcategories or products")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -57,13 +57,9 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	product.AllRating += int(comment.Rating)
-	product.CountRating++
-	product.Rating = float32(product.AllRating) / float32(product.CountRating)
-
-	err = app.models.Products.Update(product)
+	err = app.models.Products.AddRating(product, int(comment.Rating))
 	if err != nil {
-		// to-do
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 	headers := make(http.Header)
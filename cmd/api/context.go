@@ -8,7 +8,16 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	claimsContextKey = contextKey("claims")
+	apiKeyContextKey = contextKey("apiKey")
+
+	requestIDContextKey = contextKey("requestID")
+
+	apiKeyAllowedContextKey = contextKey("apiKeyAllowed")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -22,3 +31,61 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the bearer token the request was authenticated with,
+// or an empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+func (app *application) contextSetClaims(r *http.Request, claims *accessClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns the claims of the JWT the request was authenticated
+// with, or nil if it wasn't authenticated with a JWT.
+func (app *application) contextGetClaims(r *http.Request) *accessClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(*accessClaims)
+	return claims
+}
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil if it wasn't authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetAPIKeyAllowed(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyAllowedContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextAPIKeyAllowed(r *http.Request) bool {
+	allowed, _ := r.Context().Value(apiKeyAllowedContextKey).(bool)
+	return allowed
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID the requestID middleware gave the request,
+// or an empty string outside of it.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
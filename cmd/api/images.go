@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/storage"
+	"github.com/jumagaliev1/internal/validator"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxProductImages = 20
+
+// imageTypes maps the content types accepted for uploaded images, as sniffed
+// from their first bytes, to the extension they are stored with.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// imageURL is the URL a stored image is served from.
+func imageURL(key string) string {
+	return "/v1/images/" + key
+}
+
+// imageKey is the reverse of imageURL. It returns false for images hosted
+// elsewhere.
+func imageKey(url string) (string, bool) {
+	if !strings.HasPrefix(url, "/v1/images/") {
+		return "", false
+	}
+	return strings.TrimPrefix(url, "/v1/images/"), true
+}
+
+// productImageKey is like imageKey, but only returns true for images uploaded
+// for the given product. A product can refer to the uploads of another one,
+// whose files it must not delete.
+func productImageKey(productID int64, url string) (string, bool) {
+	key, ok := imageKey(url)
+	if !ok || !strings.HasPrefix(key, fmt.Sprintf("products/%d/", productID)) {
+		return "", false
+	}
+	return key, true
+}
+
+// checkProductImages makes sure the uploaded images a product refers to were
+// uploaded for it. Products which are yet to be created have none.
+func checkProductImages(v *validator.Validator, product *data.Product) {
+	for _, image := range product.Images {
+		if _, ok := imageKey(image); !ok {
+			continue
+		}
+		_, ok := productImageKey(product.ID, image)
+		v.Check(ok, "images", "must not contain images uploaded for other products")
+	}
+}
+
+// deleteDroppedImages deletes the files of the uploaded images which an update
+// removed from the product.
+func (app *application) deleteDroppedImages(before, after *data.Product) {
+	kept := make(map[string]bool, len(after.Images))
+	for _, image := range after.Images {
+		kept[image] = true
+	}
+
+	for _, image := range before.Images {
+		if kept[image] {
+			continue
+		}
+		if key, ok := productImageKey(before.ID, image); ok {
+			app.deleteImage(key)
+		}
+	}
+}
+
+//	@Summary		Upload Product Image
+//	@Description	Upload a JPEG, PNG, GIF or WebP image of a product as the image field of a multipart form. Its URL is added to the product's images
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			id		path		int		true	"Product ID"
+//	@Param			If-Match	header		string	false	"ETag of the product"
+//	@Param			image	formData	file	true	"Image"
+//	@Success		201		{object}	data.Product
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		412		{object}	Error
+//	@Failure		413		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/products/{id}/images [post]
+func (app *application) uploadProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	if !app.ifMatch(r, productETag(product)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	if len(product.Images) >= maxProductImages {
+		app.failedValidationResponse(w, r, map[string]string{"image": fmt.Sprintf("a product can't have more than %d images", maxProductImages)})
+		return
+	}
+
+	maxSize := app.config.storage.maxUploadSize
+	tooLarge := fmt.Sprintf("the image must not be larger than %d bytes", maxSize)
+
+	// The limit leaves room for the multipart headers around the image.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64<<10)
+
+	image, err := readImagePart(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, tooLarge)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if image == nil {
+		app.failedValidationResponse(w, r, map[string]string{"image": "must be provided"})
+		return
+	}
+
+	var body bytes.Buffer
+	_, err = io.Copy(&body, io.LimitReader(image, maxSize+1))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, tooLarge)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if int64(body.Len()) > maxSize {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, tooLarge)
+		return
+	}
+
+	// The content type the client claims is ignored in favour of what the
+	// content looks like.
+	contentType := http.DetectContentType(body.Bytes())
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"image": "must be a JPEG, PNG, GIF or WebP image"})
+		return
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	key := fmt.Sprintf("products/%d/%s%s", product.ID, hex.EncodeToString(randomBytes), ext)
+
+	err = app.storage.Put(r.Context(), key, bytes.NewReader(body.Bytes()), int64(body.Len()), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := *product
+	product.Images = append(product.Images, imageURL(key))
+
+	err = app.models.Products.Update(product)
+	if err != nil {
+		app.deleteImage(key)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditProductUpdate, data.AuditEntityProduct, product.ID, before, product)
+
+	headers := make(http.Header)
+	headers.Set("Location", imageURL(key))
+	headers.Set("ETag", productETag(product))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"product": product}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readImagePart returns the image field of a multipart form request, or nil
+// when there is none.
+func readImagePart(r *http.Request) (io.Reader, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if part.FormName() == "image" {
+			return part, nil
+		}
+	}
+}
+
+//	@Summary		Delete Product Image
+//	@Description	Remove an uploaded image from a product and delete the file
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			id		path		int		true	"Product ID"
+//	@Param			name	path		string	true	"File name of the image, the last segment of its URL"
+//	@Param			If-Match	header		string	false	"ETag of the product"
+//	@Success		200		{object}	data.Product
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		412		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/products/{id}/images/{name} [delete]
+func (app *application) deleteProductImageHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	if !app.ifMatch(r, productETag(product)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	key := fmt.Sprintf("products/%d/%s", product.ID, name)
+
+	before := *product
+	images := make([]string, 0, len(product.Images))
+	for _, image := range product.Images {
+		if image != imageURL(key) {
+			images = append(images, image)
+		}
+	}
+	if len(images) == len(product.Images) {
+		app.notFoundResponse(w, r)
+		return
+	}
+	product.Images = images
+
+	err := app.models.Products.Update(product)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteImage(key)
+	app.audit(r, data.AuditProductUpdate, data.AuditEntityProduct, product.ID, before, product)
+
+	headers := make(http.Header)
+	headers.Set("ETag", productETag(product))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteImage removes a stored image no product refers to any more. A failure
+// only leaves an orphaned file behind, so it is logged rather than returned.
+func (app *application) deleteImage(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := app.storage.Delete(ctx, key)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"key": key})
+	}
+}
+
+//	@Summary		Show Image
+//	@Description	An uploaded image
+//	@Tags			Product
+//	@Produce		image/jpeg,image/png,image/gif,image/webp
+//	@Param			key	path		string	true	"Key of the image"
+//	@Success		200	{file}		file
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/images/{key} [get]
+func (app *application) showImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+
+	content, contentType, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer content.Close()
+
+	// Keys are random and never reused, so an image never changes.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, content)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
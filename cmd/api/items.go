@@ -30,11 +30,6 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 	}
 	user := app.contextGetUser(r)
 
-	if user.Role == data.Roles_name[2] {
-		app.permissionRequiredResponse(w, r)
-		return
-	}
-
 	product := &data.Product{
 		User:        user.ID,
 		Title:       input.Title,
@@ -47,7 +42,13 @@ func (app *application) createProductHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 
-	if data.ValidateProduct(v, product); !v.Valid() {
+	data.ValidateProduct(v, product)
+	checkProductImages(v, product)
+	if err := app.checkCategory(v, product.Category); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -94,28 +95,45 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+	product.Variants, err = app.models.Variants.GetAllForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	product.Attributes, err = app.models.Attributes.GetForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	comments, err := app.models.Comments.GetByProduct(product)
 	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product, "comments": comments}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", productETag(product))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product, "comments": comments}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 //	@Summary		Update Product
-//	@Description	Update Product for Shop
+//	@Description	Update Product for Shop. Send the ETag of the product as If-Match to only update the version you read
 //	@Security		ApiKeyAuth
 //	@Tags			Product
 //	@Accept			json
 //	@Produce		json
-//	@Param			input	body		data.InputUpdateProduct	true	"Input"
-//	@Success		200		{object}	data.Product
-//	@Failure		422		{object}	Error
-//	@Failure		404		{object}	Error
-//	@Failure		500		{object}	Error
+//	@Param			id			path		int						true	"Product ID"
+//	@Param			If-Match	header		string					false	"ETag of the product"
+//	@Param			input		body		data.InputUpdateProduct	true	"Input"
+//	@Success		200			{object}	data.Product
+//	@Failure		409			{object}	Error
+//	@Failure		412			{object}	Error
+//	@Failure		422			{object}	Error
+//	@Failure		404			{object}	Error
+//	@Failure		500			{object}	Error
 //	@Router			/products/{id} [patch]
 func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
@@ -134,12 +152,18 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	input := &data.InputUpdateProduct{}
-	user := app.contextGetUser(r)
-	if product.User != user.ID && user.Role != data.Roles_name[0] {
-		app.permissionRequiredResponse(w, r)
+	if !app.canManageProduct(w, r, product) {
 		return
 	}
+
+	if !app.ifMatch(r, productETag(product)) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	before := *product
+
+	input := &data.InputUpdateProduct{}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
@@ -149,8 +173,6 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.Category = *input.Category
 	}
 
-	product.User = user.ID
-
 	if input.Title != nil {
 		product.Title = *input.Title
 	}
@@ -177,25 +199,65 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 
 	v := validator.New()
 
-	if data.ValidateProduct(v, product); !v.Valid() {
+	// Price and stock belong to the variants. They can only be set on the
+	// product when there is a single variant to pass them on to.
+	var variant *data.Variant
+	if input.Price != nil || input.Stock != nil {
+		variants, err := app.models.Variants.GetAllForProduct(product.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(variants) == 1 {
+			variant = variants[0]
+			variant.Price = product.Price
+			variant.Stock = product.Stock
+		} else {
+			v.AddError("price", "must be set per variant for products with several variants")
+		}
+	}
+
+	data.ValidateProduct(v, product)
+	checkProductImages(v, product)
+	if err := app.checkCategory(v, product.Category); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Products.Update(product)
+	if variant != nil {
+		err = app.models.Products.UpdateWithVariant(product, variant)
+	} else {
+		err = app.models.Products.Update(product)
+	}
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	app.deleteDroppedImages(&before, product)
+	app.audit(r, data.AuditProductUpdate, data.AuditEntityProduct, product.ID, before, product)
+
+	headers := make(http.Header)
+	headers.Set("ETag", productETag(product))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 //	@Summary		Delete Product
-//	@Description	Delete Product for Shop
+//	@Description	Move a product to the trash, from which it can be restored until it is purged
 //	@Security		ApiKeyAuth
 //	@Tags			Product
 //	@Accept			json
@@ -212,6 +274,21 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
 	err = app.models.Products.Delete(id)
 	if err != nil {
 		switch {
@@ -223,7 +300,9 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "product successfully deleted"}, nil)
+	app.audit(r, data.AuditProductDelete, data.AuditEntityProduct, product.ID, product, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "product moved to the trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -235,15 +314,25 @@ func (app *application) deleteProductHandler(w http.ResponseWriter, r *http.Requ
 //	@Tags			Product
 //	@Accept			json
 //	@Produce		json
-//	@Param			title		query		string	false	"title"
-//	@Param			category	query		int		false	"category"
-//	@Param			page		query		int		false	"page"
-//	@Param			page_size	query		int		false	"Page size"
-//	@Param			sort		query		string	false	"sort"
-//	@Success		200			{object}	[]data.Product
-//	@Failure		422			{object}	Error
-//	@Failure		404			{object}	Error
-//	@Failure		500			{object}	Error
+//	@Param			title			query		string	false	"Search in titles and descriptions, tolerating typos in titles"
+//	@Param			category		query		int		false	"Category ID, includes its subcategories"
+//	@Param			min_price		query		int		false	"Minimum price"
+//	@Param			max_price		query		int		false	"Maximum price"
+//	@Param			in_stock		query		bool	false	"Only products in stock"
+//	@Param			min_rating		query		number	false	"Minimum rating, 0 to 5"
+//	@Param			seller			query		int		false	"Seller user ID"
+//	@Param			created_after	query		string	false	"Created at or after, RFC 3339 or YYYY-MM-DD"
+//	@Param			created_before	query		string	false	"Created before, RFC 3339 or YYYY-MM-DD"
+//	@Param			attr.{code}		query		string	false	"Attribute filter, e.g. attr.brand=Apple,Samsung or attr.ram_gb[gte]=8 (operators eq, gt, gte, lt, lte)"
+//	@Param			facets			query		string	false	"Comma separated attribute codes to count values for in metadata.facets"
+//	@Param			page			query		int		false	"page"
+//	@Param			cursor			query		string	false	"Cursor from metadata.next_cursor or metadata.prev_cursor, instead of page"
+//	@Param			page_size		query		int		false	"Page size"
+//	@Param			sort			query		string	false	"Sort field, prefix - for descending. relevance orders title search results by rank"
+//	@Success		200				{object}	[]data.Product
+//	@Failure		422				{object}	Error
+//	@Failure		404				{object}	Error
+//	@Failure		500				{object}	Error
 //	@Router			/products [get]
 func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
 	input := &data.InputListProducts{}
@@ -254,18 +343,36 @@ func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Reque
 
 	input.Title = app.readString(qs, "title", "")
 	input.Category = app.readInt(qs, "category", 0, v)
+	input.MinPrice = app.readInt(qs, "min_price", 0, v)
+	input.MaxPrice = app.readInt(qs, "max_price", 0, v)
+	input.InStock = app.readBool(qs, "in_stock", false, v)
+	input.MinRating = app.readFloat(qs, "min_rating", 0, v)
+	input.Seller = app.readInt(qs, "seller", 0, v)
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.CreatedBefore = app.readTime(qs, "created_before", v)
+	input.Attributes = app.readAttributeFilters(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "category", "price", "rating", "-id", "-title", "-category", "-price", "-rating"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.cursorKey
+	input.Filters.SortSafelist = []string{"id", "title", "category", "price", "rating", "relevance", "-id", "-title", "-category", "-price", "-rating"}
+
+	data.ValidateListProducts(v, *input)
+	v.Check(len(input.Facets) <= 10, "facets", "must not contain more than 10 attribute codes")
+	v.Check(validator.Unique(input.Facets), "facets", "must not contain duplicate values")
+	for _, code := range input.Facets {
+		v.Check(validator.Matches(code, data.AttributeCodeRX), "facets", "must only contain valid attribute codes")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	products, metadata, err := app.models.Products.GetAll(input.Title, input.Category, input.Filters)
+	products, metadata, err := app.models.Products.GetAll(*input)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -276,3 +383,30 @@ func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// productETag identifies the version of a product for the ETag and If-Match
+// headers.
+func productETag(product *data.Product) string {
+	return fmt.Sprintf(`"%d"`, product.Version)
+}
+
+// canManageProduct lets sellers change their own products and users with the
+// products:manage permission change anyone's. Otherwise it writes a 403 and
+// returns false.
+func (app *application) canManageProduct(w http.ResponseWriter, r *http.Request, product *data.Product) bool {
+	if product.User == app.contextGetUser(r).ID {
+		return true
+	}
+
+	ok, err := app.hasPermission(r, data.PermissionProductsManage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		app.permissionRequiredResponse(w, r)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/jwt"
+	"strconv"
+	"time"
+)
+
+// accessClaims is the payload of the authentication tokens issued in JWT mode.
+// It carries enough of the user for authenticate to work without a database
+// round trip.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Session   string `json:"sid,omitempty"`
+	Email     string `json:"email"`
+	FirstName string `json:"given_name"`
+	LastName  string `json:"family_name"`
+	Role      string `json:"role"`
+	Activated bool   `json:"activated"`
+}
+
+func (c *accessClaims) user() (*data.User, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &data.User{
+		ID:        id,
+		FirstName: c.FirstName,
+		LastName:  c.LastName,
+		Email:     c.Email,
+		Role:      c.Role,
+		Activated: c.Activated,
+	}, nil
+}
+
+func (c *accessClaims) family() []byte {
+	if c == nil {
+		return nil
+	}
+
+	family, err := hex.DecodeString(c.Session)
+	if err != nil || len(family) == 0 {
+		return nil
+	}
+	return family
+}
+
+func (app *application) newAccessJWT(user *data.User, family []byte) (*data.Token, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.accessTTL)
+
+	claims := &accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Issuer:    app.config.jwt.issuer,
+			Subject:   strconv.FormatInt(user.ID, 10),
+			IssuedAt:  jwt.NumericDate(now),
+			ExpiresAt: expiry.Unix(),
+		},
+		Session:   hex.EncodeToString(family),
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      user.Role,
+		Activated: user.Activated,
+	}
+
+	signed, err := app.jwt.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+func (app *application) verifyAccessJWT(token string) (*accessClaims, error) {
+	var claims accessClaims
+
+	err := app.jwt.Verify(token, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != app.config.jwt.issuer || app.denylist.IsRevoked(claims.RegisteredClaims) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// revokeAccessJWT puts a single token on the denylist, both locally and in the
+// database so that other instances pick it up on their next sync.
+func (app *application) revokeAccessJWT(claims *accessClaims) error {
+	user, err := claims.user()
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Unix(claims.ExpiresAt, 0)
+
+	err = app.models.Revocations.Insert(&data.Revocation{
+		JTI:    claims.ID,
+		UserID: user.ID,
+		Expiry: expiry,
+	})
+	if err != nil {
+		return err
+	}
+
+	app.denylist.Revoke(claims.ID, expiry)
+	return nil
+}
+
+// revokeAllTokens logs the user out of every session: opaque tokens are deleted
+// and, in JWT mode, every access token issued so far is denied.
+func (app *application) revokeAllTokens(userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.jwt == nil {
+		return nil
+	}
+
+	revocation := &data.Revocation{
+		UserID: userID,
+		Expiry: time.Now().Add(app.config.auth.accessTTL),
+	}
+
+	err := app.models.Revocations.Insert(revocation)
+	if err != nil {
+		return err
+	}
+
+	app.denylist.RevokeSubject(strconv.FormatInt(userID, 10), revocation.RevokedAt, revocation.Expiry)
+	return nil
+}
+
+// loadDenylist adds the revocations stored in the database, which includes
+// those made by other instances, to the local denylist.
+func (app *application) loadDenylist() error {
+	revocations, err := app.models.Revocations.GetAllActive()
+	if err != nil {
+		return err
+	}
+
+	for _, revocation := range revocations {
+		if revocation.JTI != "" {
+			app.denylist.Revoke(revocation.JTI, revocation.Expiry)
+		} else {
+			app.denylist.RevokeSubject(strconv.FormatInt(revocation.UserID, 10), revocation.RevokedAt, revocation.Expiry)
+		}
+	}
+
+	app.denylist.Prune(time.Now())
+	return nil
+}
+
+// syncDenylist periodically reloads the denylist from the database and drops
+// revocations of tokens which have expired, until ctx is cancelled.
+func (app *application) syncDenylist(ctx context.Context) {
+	ticker := time.NewTicker(app.config.jwt.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := app.models.Revocations.DeleteExpired()
+			if err == nil {
+				err = app.loadDenylist()
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// loginLimiter tracks failed logins per key. Every failure doubles the time the
+// key has to wait before its next attempt, and after maxFailures in a row the
+// key is locked out completely for the lockout period.
+//
+// The attempts are only kept in the memory of each instance. With several
+// instances behind a load balancer the limits apply to every instance on its
+// own, and lifting a lockout only lifts it on the instance which handles that
+// request.
+type loginLimiter struct {
+	mu          sync.Mutex
+	attempts    map[string]*loginAttempts
+	maxFailures int
+	backoff     time.Duration
+	lockout     time.Duration
+}
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLoginLimiter(maxFailures int, backoff, lockout time.Duration) *loginLimiter {
+	return &loginLimiter{
+		attempts:    make(map[string]*loginAttempts),
+		maxFailures: maxFailures,
+		backoff:     backoff,
+		lockout:     lockout,
+	}
+}
+
+// wait returns how long key has to wait before it may try again, zero if it may
+// try right away.
+func (l *loginLimiter) wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok {
+		return 0
+	}
+
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now)
+	}
+
+	if a.failures == 0 {
+		return 0
+	}
+
+	delay := l.backoff << (a.failures - 1)
+	if delay <= 0 || delay > l.lockout {
+		delay = l.lockout
+	}
+
+	if next := a.lastFailure.Add(delay); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt and reports whether it locked the key out.
+func (l *loginLimiter) fail(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok {
+		a = &loginAttempts{}
+		l.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	if a.failures >= l.maxFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(l.lockout)
+		return true
+	}
+	return false
+}
+
+func (l *loginLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+}
+
+// prune forgets keys which have been quiet for longer than the lockout period.
+func (l *loginLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, a := range l.attempts {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > l.lockout {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// pruneLoginAttempts keeps the login limiters from growing without bound,
+// until ctx is cancelled.
+func (app *application) pruneLoginAttempts(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			app.emailLimiter.prune(now)
+			app.ipLimiter.prune(now)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/totp"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const totpIssuer = "Kaspi"
+
+//	@Summary		Enroll TOTP
+//	@Description	Start two-factor authentication enrollment for a seller or admin account
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		409	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/users/me/mfa/totp [post]
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Enrollment is open to the accounts which sell or administer, whatever
+	// role they were given those permissions with.
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !permissions.Include(data.PermissionProductsWrite) && !permissions.Include(data.PermissionUsersManage) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.SetTOTPSecret(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Confirm TOTP
+//	@Description	Finish two-factor authentication enrollment and receive recovery codes
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputTOTPCode	true	"Code from the authenticator app"
+//	@Success		200		{object}	[]string
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me/mfa/totp/confirm [post]
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputTOTPCode
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	enrollment, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrollment.Enabled {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	counter, ok := totp.Validate(enrollment.Secret, input.Code, time.Now(), 1)
+	if !ok {
+		v.AddError("code", "invalid authentication code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.MFA.UseTOTPCounter(user.ID, counter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.EnableTOTP(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	codes, err := app.models.MFA.NewRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		MFA Login
+//	@Description	Exchange an mfa_required challenge token and a TOTP or recovery code for an authentication token
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputMFAChallenge	true	"Challenge token and code"
+//	@Success		201		{object}	data.Token
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/tokens/mfa [post]
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputMFAChallenge
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	if input.RecoveryCode == "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa_token", "invalid or expired multi-factor authentication token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// A challenge can only be answered once, right or wrong, so codes can't be
+	// guessed without going through the password check again.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = app.models.MFA.UseRecoveryCode(user.ID, input.RecoveryCode)
+	} else {
+		err = app.checkTOTPCode(user.ID, input.Code)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, data.ErrCodeReused):
+			app.loginFailed(strings.ToLower(user.Email), app.clientIP(r))
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeAuthenticationTokens(w, r, user, nil, http.StatusCreated)
+}
+
+// checkTOTPCode validates a code against the user's enabled secret, returning
+// ErrRecordNotFound for a wrong code and ErrCodeReused for a replayed one.
+func (app *application) checkTOTPCode(userID int64, code string) error {
+	enrollment, err := app.models.MFA.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	if !enrollment.Enabled {
+		return data.ErrRecordNotFound
+	}
+
+	counter, ok := totp.Validate(enrollment.Secret, code, time.Now(), 1)
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+
+	return app.models.MFA.UseTOTPCounter(userID, counter)
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/julienschmidt/httprouter"
 	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/jwt"
 	"github.com/jumagaliev1/internal/validator"
 	"net/http"
 	"strings"
@@ -21,13 +25,59 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestID tags every request with an ID, taken from the X-Request-ID header
+// when the client or a proxy sent a sane one, and echoes it in the response so
+// log entries and audit events can be matched up with requests.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			randomBytes := make([]byte, 16)
+			_, err := rand.Read(randomBytes)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
 		authorizationHeader := r.Header.Get("Authorization")
 
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			// One credential per request, so it is never ambiguous whose
+			// permissions apply.
+			if authorizationHeader != "" {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+
+			app.authenticateAPIKey(w, r, apiKey, next)
+			return
+		}
+
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
@@ -42,6 +92,27 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
+		if app.jwt != nil && jwt.LooksLikeJWT(token) {
+			claims, err := app.verifyAccessJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user, err := claims.user()
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -60,11 +131,65 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if user.Suspended {
+			app.accountSuspendedResponse(w, r)
+			return
+		}
+
+		err = app.models.Tokens.Touch(data.ScopeAuthentication, token)
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		next.ServeHTTP(w, r)
 	})
 }
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, apiKey string, next http.Handler) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, apiKey); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	key, user, err := app.models.APIKeys.GetForKey(apiKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Touch(key.ID)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// allowAPIKey opens a route to requests authenticated with an API key. Other
+// routes, account management in particular, need a login.
+func (app *application) allowAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, app.contextSetAPIKeyAllowed(r))
+	})
+}
+
 func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -72,17 +197,72 @@ func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
+		if app.contextGetAPIKey(r) != nil && !app.contextAPIKeyAllowed(r) {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) checkPermission(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func (app *application) requireActivatedUser(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		if user.Role == data.Roles_name[2] {
-			app.permissionRequiredResponse(w, r)
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// hasPermission reports whether the user of the request has the permission.
+// An API key can narrow down the permissions of its user, never widen them.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	if key := app.contextGetAPIKey(r); key != nil && !data.Permissions(key.Scopes).Include(code) {
+		return false, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
+
+func (app *application) requirePermission(code string, next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, err := app.hasPermission(r, code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.allowAPIKey(app.requireActivatedUser(fn))
+}
+
+// routeByParam sends requests whose route parameter name has one of the values
+// in routes to that handler and all others to next. httprouter can't register a
+// static path like /v1/products/suggest next to /v1/products/:id, so such paths
+// are registered as the parameterised route and told apart here.
+func (app *application) routeByParam(name string, routes map[string]http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := routes[httprouter.ParamsFromContext(r.Context()).ByName(name)]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
 }
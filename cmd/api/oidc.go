@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/oidc"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oidcLoginTTL is how long the user has to complete the login at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+var (
+	errIdentityNoEmail    = errors.New("identity has no email address")
+	errIdentityEmailTaken = errors.New("identity email belongs to another account")
+)
+
+func (app *application) readProviderParam(r *http.Request) (*oidc.Provider, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	provider, ok := app.oidc[params.ByName("provider")]
+	return provider, ok
+}
+
+//	@Summary		Start OIDC Login
+//	@Description	Start signing in with an external identity provider. Send the user to the returned authorization URL
+//	@Tags			User
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	string
+//	@Failure		404			{object}	Error
+//	@Failure		500			{object}	Error
+//	@Failure		502			{object}	Error
+//	@Router			/oidc/{provider}/login [get]
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readProviderParam(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	login := &data.OIDCLogin{
+		Provider: provider.Name(),
+		Expiry:   time.Now().Add(oidcLoginTTL),
+	}
+
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		var err error
+		*value, err = oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.identityProviderErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.InsertLogin(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authorizationURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Complete OIDC Login
+//	@Description	Redirect target of the identity provider. Signs the user in, creating an account on the first login
+//	@Tags			User
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Param			code		query		string	true	"Authorization code"
+//	@Param			state		query		string	true	"State"
+//	@Success		201			{object}	data.Token
+//	@Failure		401			{object}	Error
+//	@Failure		403			{object}	Error
+//	@Failure		404			{object}	Error
+//	@Failure		422			{object}	Error
+//	@Failure		500			{object}	Error
+//	@Failure		502			{object}	Error
+//	@Router			/oidc/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readProviderParam(r)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if qs.Get("error") != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, "the identity provider declined the login: "+qs.Get("error"))
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v := validator.New()
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.Identities.ConsumeLogin(provider.Name(), state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrExchange):
+			app.logError(r, err)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.identityProviderErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(provider.Name(), idToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errIdentityNoEmail):
+			v.AddError("email", "the identity provider did not share an email address")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errIdentityEmailTaken):
+			v.AddError("email", "a user with this email address already exists, sign in with your password to link accounts")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// userForIdentity returns the user linked to the identity. On the first login
+// the identity is linked to the account with the same, verified, email address
+// or a new customer account without a password is created for it.
+func (app *application) userForIdentity(provider string, idToken *oidc.IDToken) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(provider, idToken.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if idToken.Email == "" {
+		return nil, errIdentityNoEmail
+	}
+
+	identity := &data.Identity{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	}
+
+	user, err = app.models.Users.GetByEmail(idToken.Email)
+	switch {
+	case err == nil:
+		// Only an address the provider vouches for may take over an
+		// existing account.
+		if !idToken.EmailVerified {
+			return nil, errIdentityEmailTaken
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		return app.registerIdentityUser(idToken, identity)
+	default:
+		return nil, err
+	}
+
+	identity.UserID = user.ID
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// registerIdentityUser creates the account for a first login through an
+// identity provider, linked to the identity.
+func (app *application) registerIdentityUser(idToken *oidc.IDToken, identity *data.Identity) (*data.User, error) {
+	firstName, lastName := idToken.GivenName, idToken.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(idToken.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	user := &data.User{
+		FirstName: firstName,
+		LastName:  strings.TrimSpace(lastName),
+		Email:     idToken.Email,
+		Role:      data.Roles_name[2],
+		Activated: idToken.EmailVerified,
+	}
+
+	token, err := app.models.Users.Register(user, data.RolePermissions[user.Role], 3*24*time.Hour, identity)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return nil, errIdentityEmailTaken
+		}
+		return nil, err
+	}
+
+	if token == nil {
+		return user, nil
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return user, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"github.com/jumagaliev1/internal/data"
 	"net/http"
 )
@@ -27,14 +28,20 @@ func (app *application) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	cart, err := app.models.Carts.GetByID(input.CartID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	order := &data.Order{
 		OrderStatus: data.OrderStatusCreated,
 		Cart:        *cart,
+		VariantID:   cart.Variant.ID,
 		Quantity:    input.Quantity,
-		TotalPrice:  cart.Product.Price * cart.Quantity * input.Quantity,
+		TotalPrice:  cart.Variant.Price * cart.Quantity * input.Quantity,
 	}
 
 	err = app.models.Orders.Insert(order)
@@ -43,6 +50,31 @@ func (app *application) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.audit(r, data.AuditOrderCreate, data.AuditEntityOrder, order.ID, nil, map[string]interface{}{
+		"cart_id":      cart.ID,
+		"variant_id":   order.VariantID,
+		"order_status": order.OrderStatus,
+		"quantity":     order.Quantity,
+		"total_price":  order.TotalPrice,
+	})
+
+	user := app.contextGetUser(r)
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"firstName":  user.FirstName,
+			"orderID":    order.ID,
+			"productID":  cart.Product.ID,
+			"quantity":   order.Quantity,
+			"totalPrice": order.TotalPrice,
+		}
+
+		err := app.mailer.Send(user.Email, "order_confirmation.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"order": order}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -58,8 +90,10 @@ func (app *application) CreateOrder(w http.ResponseWriter, r *http.Request) {
 // @Produce		json
 // @Param			id	path		int	true	"Order ID"
 // @Success		200	{object}	string
+// @Failure		403	{object}	Error
 // @Failure		422	{object}	Error
 // @Failure		404	{object}	Error
+// @Failure		409	{object}	Error
 // @Failure		500	{object}	Error
 // @Router			/order/{id} [patch]
 func (app *application) ApproveOrder(w http.ResponseWriter, r *http.Request) {
@@ -70,17 +104,45 @@ func (app *application) ApproveOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	order, err := app.models.Orders.GetByID(int(id))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canManageProduct(w, r, &order.Cart.Product) {
 		return
 	}
 
 	if order.OrderStatus != data.OrderStatusCreated {
-		err = app.writeJSON(w, http.StatusForbidden, envelope{"message": order.OrderStatus}, nil)
+		app.writeJSON(w, http.StatusForbidden, envelope{"message": order.OrderStatus}, nil)
+		return
 	}
 
 	order.OrderStatus = data.OrderStatusFinish
 
+	err = app.models.Orders.UpdateStatus(order, data.OrderStatusCreated)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditOrderApprove, data.AuditEntityOrder, order.ID,
+		map[string]string{"order_status": data.OrderStatusCreated},
+		map[string]string{"order_status": order.OrderStatus})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": order.OrderStatus}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 // @Summary		Cancel Order
@@ -91,7 +153,9 @@ func (app *application) ApproveOrder(w http.ResponseWriter, r *http.Request) {
 // @Produce		json
 // @Param			id	path		int	true	"Order ID"
 // @Success		200	{object}	string
+// @Failure		403	{object}	Error
 // @Failure		404	{object}	Error
+// @Failure		409	{object}	Error
 // @Failure		500	{object}	Error
 // @Router			/order/{id} [delete]
 func (app *application) CancelOrder(w http.ResponseWriter, r *http.Request) {
@@ -102,15 +166,45 @@ func (app *application) CancelOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	order, err := app.models.Orders.GetByID(int(id))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the buyer can cancel their order.
+	if order.Cart.User.ID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
 	if order.OrderStatus != data.OrderStatusCreated {
-		err = app.writeJSON(w, http.StatusForbidden, envelope{"message": order.OrderStatus}, nil)
+		app.writeJSON(w, http.StatusForbidden, envelope{"message": order.OrderStatus}, nil)
+		return
 	}
 
 	order.OrderStatus = data.OrderStatusCancel
 
+	err = app.models.Orders.UpdateStatus(order, data.OrderStatusCreated)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditOrderCancel, data.AuditEntityOrder, order.ID,
+		map[string]string{"order_status": data.OrderStatusCreated},
+		map[string]string{"order_status": order.OrderStatus})
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": order.OrderStatus}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// currentUser loads the authenticated user from the database. The user in the
+// request context may have been built from JWT claims and lack the profile
+// fields and the version.
+func (app *application) currentUser(r *http.Request) (*data.User, error) {
+	return app.models.Users.GetByID(int(app.contextGetUser(r).ID))
+}
+
+// confirmPassword makes sensitive changes ask for the current password again.
+// Users who only sign in through an identity provider have none to confirm.
+// It writes the error response itself and returns false on failure. Failed
+// confirmations count against the same limits as failed logins, so a stolen
+// token can't be used to guess the password.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *data.User, plaintext string) bool {
+	if !user.Password.IsSet() {
+		return true
+	}
+
+	emailKey := strings.ToLower(user.Email)
+	ip := app.clientIP(r)
+
+	if wait := app.loginWait(emailKey, ip); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return false
+	}
+
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !match {
+		app.loginFailed(emailKey, ip)
+		app.invalidCredentialsResponse(w, r)
+		return false
+	}
+
+	app.emailLimiter.reset(emailKey)
+	return true
+}
+
+//	@Summary		Show Current User
+//	@Description	Profile of the authenticated user
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	data.User
+//	@Failure		401	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/users/me [get]
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Update Current User
+//	@Description	Change the name, phone or address of the authenticated user. Send the version to make sure nobody changed the profile in between
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputUpdateUser	true	"Input"
+//	@Success		200		{object}	data.User
+//	@Failure		400		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me [patch]
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	input := &data.InputUpdateUser{}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != user.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.FirstName != nil {
+		user.FirstName = *input.FirstName
+	}
+
+	if input.LastName != nil {
+		user.LastName = *input.LastName
+	}
+
+	if input.Phone != nil {
+		user.Phone = input.Phone
+	}
+
+	if input.Address != nil {
+		user.Address = input.Address
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Change Email
+//	@Description	Start changing the email address of the authenticated user. The new address is used once it's verified with the token sent to it
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputUpdateEmail	true	"New email and current password"
+//	@Success		202		{object}	string
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		429		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me/email [put]
+func (app *application) updateCurrentUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputUpdateEmail
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, input.Password) {
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from the current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PendingEmail = &input.Email
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the token for the latest requested address stays valid.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"firstName":        user.FirstName,
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(input.Email, "token_email_change.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to the new address containing instructions to verify it"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Verify Email
+//	@Description	Confirm a new email address with the token sent to it
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputVerifyEmail	true	"Email change token"
+//	@Success		200		{object}	data.User
+//	@Failure		400		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/email/verified [put]
+func (app *application) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputVerifyEmail
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err == nil && user.PendingEmail == nil {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Delete Current User
+//	@Description	Delete the account of the authenticated user. Personal data is anonymised, orders and comments are kept
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputDeleteAccount	true	"Current password"
+//	@Success		200		{object}	string
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		409		{object}	Error
+//	@Failure		429		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me [delete]
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputDeleteAccount
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, input.Password) {
+		return
+	}
+
+	err = app.models.Users.Delete(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The opaque tokens went with the account, this also denies the
+	// outstanding access tokens in JWT mode.
+	err = app.revokeAllTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// No changes are recorded, the personal data must not survive in the log.
+	app.audit(r, data.AuditUserDelete, data.AuditEntityUser, user.ID, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account was successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Export Current User
+//	@Description	Download every piece of personal data held about the authenticated user, as a single JSON document or a ZIP archive of JSON files
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Produce		application/zip
+//	@Param			format	query		string	false	"json (default) or zip"
+//	@Success		200		{object}	string
+//	@Failure		401		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/users/me/export [get]
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", "json")
+	if v.Check(validator.In(format, "json", "zip"), "format", "must be json or zip"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	export, err := app.collectUserData(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("kaspi-export-%d-%s", app.contextGetUser(r).ID, time.Now().UTC().Format("20060102"))
+
+	if format == "json" {
+		headers := make(http.Header)
+		headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+
+		err = app.writeJSON(w, http.StatusOK, export, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Build the archive in memory first, so that a failure can still be
+	// reported with a proper error response.
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, name := range []string{"profile", "carts", "orders", "comments", "sessions"} {
+		js, err := json.MarshalIndent(export[name], "", "\t")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		f, err := zw.Create(name + ".json")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		_, err = f.Write(append(js, '\n'))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// collectUserData gathers the personal data of the authenticated user for an
+// export. References to other records are flattened to their IDs.
+func (app *application) collectUserData(r *http.Request) (envelope, error) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	carts, err := app.models.Carts.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := app.models.Orders.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := app.models.Comments.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Tokens.GetAllForUser(user.ID, app.contextGetToken(r), app.contextGetClaims(r).family())
+	if err != nil {
+		return nil, err
+	}
+
+	exportedCarts := make([]envelope, 0, len(carts))
+	for _, cart := range carts {
+		exportedCarts = append(exportedCarts, envelope{
+			"id":         cart.ID,
+			"product_id": cart.Product.ID,
+			"variant_id": cart.Variant.ID,
+			"quantity":   cart.Quantity,
+		})
+	}
+
+	exportedOrders := make([]envelope, 0, len(orders))
+	for _, order := range orders {
+		exportedOrders = append(exportedOrders, envelope{
+			"id":           order.ID,
+			"cart_id":      order.Cart.ID,
+			"product_id":   order.Cart.Product.ID,
+			"variant_id":   order.VariantID,
+			"order_status": order.OrderStatus,
+			"quantity":     order.Quantity,
+			"total_price":  order.TotalPrice,
+			"created_at":   order.CreatedAt,
+			"updated_at":   order.UpdatedAt,
+		})
+	}
+
+	exportedComments := make([]envelope, 0, len(comments))
+	for _, comment := range comments {
+		exportedComments = append(exportedComments, envelope{
+			"id":         comment.ID,
+			"product_id": comment.Product.ID,
+			"message":    comment.Message,
+			"rating":     comment.Rating,
+			"created_at": comment.CreatedAt,
+			"updated_at": comment.UpdatedAt,
+		})
+	}
+
+	return envelope{
+		"profile": envelope{
+			"id":         user.ID,
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+			"email":      user.Email,
+			"phone":      user.Phone,
+			"address":    user.Address,
+			"role":       user.Role,
+			"activated":  user.Activated,
+			"created_at": user.CreatedAt,
+		},
+		"carts":    exportedCarts,
+		"orders":   exportedOrders,
+		"comments": exportedComments,
+		"sessions": sessions,
+	}, nil
+}
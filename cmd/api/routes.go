@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/julienschmidt/httprouter"
+	"github.com/jumagaliev1/internal/data"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"net/http"
 )
@@ -13,27 +14,75 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.Handler(http.MethodGet, "/v1/healthcheck", app.authenticate(http.HandlerFunc(app.healthcheckHandler)))
-	router.Handler(http.MethodGet, "/v1/products", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.listProductsHandler))))
-	router.Handler(http.MethodPost, "/v1/products", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.createProductHandler))))
-	router.Handler(http.MethodGet, "/v1/products/:id", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.showProductHandler))))
-	router.Handler(http.MethodPatch, "/v1/products/:id", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.updateProductHandler))))
-	router.Handler(http.MethodDelete, "/v1/products/:id", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.deleteProductHandler))))
+	router.Handler(http.MethodGet, "/v1/products", app.authenticate(app.allowAPIKey(app.requireAuthenticatedUser(http.HandlerFunc(app.listProductsHandler)))))
+	router.Handler(http.MethodPost, "/v1/products", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.createProductHandler))))
+	router.Handler(http.MethodGet, "/v1/products/:id", app.routeByParam("id", map[string]http.Handler{
+		"suggest": app.authenticate(app.allowAPIKey(app.requireAuthenticatedUser(http.HandlerFunc(app.suggestProductsHandler)))),
+		"trash":   app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.listDeletedProductsHandler))),
+	}, app.authenticate(app.allowAPIKey(app.requireAuthenticatedUser(http.HandlerFunc(app.showProductHandler))))))
+	router.Handler(http.MethodPatch, "/v1/products/:id", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.updateProductHandler))))
+	router.Handler(http.MethodDelete, "/v1/products/:id", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.deleteProductHandler))))
+	router.Handler(http.MethodPost, "/v1/products/:id/images", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.uploadProductImageHandler))))
+	router.Handler(http.MethodDelete, "/v1/products/:id/images/:name", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.deleteProductImageHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/images/*key", app.showImageHandler)
+	router.Handler(http.MethodPost, "/v1/products/:id/restore", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.restoreProductHandler))))
+	router.Handler(http.MethodGet, "/v1/products/:id/variants", app.authenticate(app.allowAPIKey(app.requireAuthenticatedUser(http.HandlerFunc(app.listVariantsHandler)))))
+	router.Handler(http.MethodPost, "/v1/products/:id/variants", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.createVariantHandler))))
+	router.Handler(http.MethodPatch, "/v1/products/:id/variants/:variant_id", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.updateVariantHandler))))
+	router.Handler(http.MethodDelete, "/v1/products/:id/variants/:variant_id", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.deleteVariantHandler))))
+	router.Handler(http.MethodPut, "/v1/products/:id/attributes", app.authenticate(app.requirePermission(data.PermissionProductsWrite, http.HandlerFunc(app.setProductAttributesHandler))))
 
-	router.Handler(http.MethodPost, "/v1/comment", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.createCommentHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.listCategoriesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id", app.showCategoryHandler)
+	router.Handler(http.MethodPost, "/v1/categories", app.authenticate(app.requirePermission(data.PermissionCategoriesManage, http.HandlerFunc(app.createCategoryHandler))))
+	router.Handler(http.MethodPatch, "/v1/categories/:id", app.authenticate(app.requirePermission(data.PermissionCategoriesManage, http.HandlerFunc(app.updateCategoryHandler))))
+	router.Handler(http.MethodDelete, "/v1/categories/:id", app.authenticate(app.requirePermission(data.PermissionCategoriesManage, http.HandlerFunc(app.deleteCategoryHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:id/attributes", app.listCategoryAttributesHandler)
+	router.Handler(http.MethodPost, "/v1/categories/:id/attributes", app.authenticate(app.requirePermission(data.PermissionCategoriesManage, http.HandlerFunc(app.createCategoryAttributeHandler))))
+	router.Handler(http.MethodDelete, "/v1/categories/:id/attributes/:attribute_id", app.authenticate(app.requirePermission(data.PermissionCategoriesManage, http.HandlerFunc(app.deleteCategoryAttributeHandler))))
 
-	router.Handler(http.MethodPost, "/v1/cart", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.CreateCart))))
+	router.Handler(http.MethodPost, "/v1/comment", app.authenticate(app.requirePermission(data.PermissionCommentsWrite, http.HandlerFunc(app.createCommentHandler))))
 
-	router.Handler(http.MethodPost, "/v1/order", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.CreateOrder))))
-	router.Handler(http.MethodDelete, "/v1/order/:id", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.CancelOrder))))
-	router.Handler(http.MethodPatch, "/v1/order/:id", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.ApproveOrder))))
+	router.Handler(http.MethodPost, "/v1/cart", app.authenticate(app.requirePermission(data.PermissionCartsWrite, http.HandlerFunc(app.CreateCart))))
+
+	router.Handler(http.MethodPost, "/v1/order", app.authenticate(app.requirePermission(data.PermissionOrdersWrite, http.HandlerFunc(app.CreateOrder))))
+	router.Handler(http.MethodDelete, "/v1/order/:id", app.authenticate(app.requirePermission(data.PermissionOrdersWrite, http.HandlerFunc(app.CancelOrder))))
+	router.Handler(http.MethodPatch, "/v1/order/:id", app.authenticate(app.requirePermission(data.PermissionOrdersApprove, http.HandlerFunc(app.ApproveOrder))))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email/verified", app.verifyEmailHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/:provider/callback", app.oidcCallbackHandler)
+	router.Handler(http.MethodDelete, "/v1/tokens/authentication", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.deleteAuthenticationTokenHandler))))
+	router.Handler(http.MethodDelete, "/v1/tokens/authentication/all", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.deleteAllAuthenticationTokensHandler))))
+	router.Handler(http.MethodGet, "/v1/users/me", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.showCurrentUserHandler))))
+	router.Handler(http.MethodPatch, "/v1/users/me", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.updateCurrentUserHandler))))
+	router.Handler(http.MethodDelete, "/v1/users/me", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.deleteCurrentUserHandler))))
+	router.Handler(http.MethodGet, "/v1/users/me/export", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.exportCurrentUserHandler))))
+	router.Handler(http.MethodPut, "/v1/users/me/email", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.updateCurrentUserEmailHandler))))
+	router.Handler(http.MethodGet, "/v1/users/me/sessions", app.authenticate(app.requireAuthenticatedUser(http.HandlerFunc(app.listSessionsHandler))))
+	router.Handler(http.MethodGet, "/v1/users/me/api-keys", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.listAPIKeysHandler))))
+	router.Handler(http.MethodPost, "/v1/users/me/api-keys", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.createAPIKeyHandler))))
+	router.Handler(http.MethodDelete, "/v1/users/me/api-keys/:id", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.deleteAPIKeyHandler))))
+	router.Handler(http.MethodPost, "/v1/users/me/mfa/totp", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.enrollTOTPHandler))))
+	router.Handler(http.MethodPost, "/v1/users/me/mfa/totp/confirm", app.authenticate(app.requireActivatedUser(http.HandlerFunc(app.confirmTOTPHandler))))
+
+	router.Handler(http.MethodGet, "/v1/admin/users", app.authenticate(app.requirePermission(data.PermissionUsersManage, http.HandlerFunc(app.listUsersHandler))))
+	router.Handler(http.MethodPatch, "/v1/admin/users/:id", app.authenticate(app.requirePermission(data.PermissionUsersManage, http.HandlerFunc(app.updateUserHandler))))
+	router.Handler(http.MethodPost, "/v1/admin/users/:id/impersonate", app.authenticate(app.requirePermission(data.PermissionUsersManage, http.HandlerFunc(app.impersonateUserHandler))))
+	router.Handler(http.MethodPost, "/v1/admin/users/:id/unlock", app.authenticate(app.requirePermission(data.PermissionUsersManage, http.HandlerFunc(app.unlockUserHandler))))
+	router.Handler(http.MethodGet, "/v1/admin/audit", app.authenticate(app.requirePermission(data.PermissionAuditRead, http.HandlerFunc(app.listAuditEventsHandler))))
 
 	//return app.recoverPanic(app.authenticate(router))
 	router.HandlerFunc(http.MethodGet, "/swagger/*any", httpSwagger.Handler(
 		httpSwagger.URL("http://localhost:4000/static/swagger.json")))
 
 	router.ServeFiles("/static/*filepath", http.Dir("docs"))
-	return app.recoverPanic(router)
+	return app.recoverPanic(app.requestID(router))
 }
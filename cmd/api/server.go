@@ -22,6 +22,24 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	// ctx is cancelled on shutdown to stop the long running background jobs.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app.background(func() {
+		app.pruneLoginAttempts(ctx)
+	})
+
+	app.background(func() {
+		app.purgeDeletedProducts(ctx)
+	})
+
+	if app.jwt != nil {
+		app.background(func() {
+			app.syncDenylist(ctx)
+		})
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -32,17 +50,31 @@ func (app *application) serve() error {
 		app.logger.PrintInfo("caught signal", map[string]string{
 			"signal": s.String(),
 		})
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// The timeout gets its own names so that cancel below still stops
+		// the background jobs rather than this context.
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
 
-		shutdownError <- srv.Shutdown(ctx)
+		cancel()
+		app.wg.Wait()
+		shutdownError <- nil
 	}()
 
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
 	})
-	
+
 	err := srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
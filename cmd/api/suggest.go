@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"unicode/utf8"
+)
+
+//	@Summary		Suggest Products
+//	@Description	Search-as-you-type completions: product titles and categories matching the start of q, or similar to it
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			q		query		string	true	"What the user typed so far"
+//	@Param			limit	query		int		false	"Maximum number of titles and of categories, 1 to 20"
+//	@Success		200		{object}	object
+//	@Failure		401		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/products/suggest [get]
+func (app *application) suggestProductsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	q := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(q != "", "q", "must be provided")
+	v.Check(utf8.RuneCountInString(q) <= 100, "q", "must not be more than 100 characters long")
+	v.Check(limit > 0 && limit <= 20, "limit", "must be between 1 and 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	titles, err := app.models.Products.Suggest(q, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	categories, err := app.models.Categories.Suggest(q, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "private, max-age=60")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"titles": titles, "categories": categories}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/jumagaliev1/internal/data"
 	"github.com/jumagaliev1/internal/validator"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,10 +16,12 @@ import (
 //	@Accept			json
 //	@Produce		json
 //	@Param			input	body		data.InputAuthUser	true	"Input for Auth user"
+//	@Success		200		{object}	data.Token	"mfa_required challenge"
 //	@Success		201		{object}	data.Token
 //	@Failure		400		{object}	Error
 //	@Failure		401		{object}	Error
 //	@Failure		422		{object}	Error
+//	@Failure		429		{object}	Error
 //	@Failure		500		{object}	Error
 //	@Router			/tokens/authentication [post]
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -39,10 +43,21 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	emailKey := strings.ToLower(input.Email)
+	ip := app.clientIP(r)
+
+	if wait := app.loginWait(emailKey, ip); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			data.MatchesNothing(input.Password)
+			app.loginFailed(emailKey, ip)
+			app.audit(r, data.AuditLoginFailed, data.AuditEntityUser, 0, nil, map[string]string{"email": emailKey})
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -57,17 +72,370 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.loginFailed(emailKey, ip)
+		app.audit(r, data.AuditLoginFailed, data.AuditEntityUser, user.ID, nil, nil)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	app.emailLimiter.reset(emailKey)
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a successful first factor login: users with
+// two-factor authentication get an MFA challenge, everybody else their tokens.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	enrollment, err := app.models.MFA.GetTOTP(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrollment != nil && enrollment.Enabled {
+		challenge, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"mfa_required": true, "mfa_token": challenge}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeAuthenticationTokens(w, r, user, nil, http.StatusCreated)
+}
+
+// loginWait returns how long a login for the email from the IP address has to
+// wait because of earlier failures.
+func (app *application) loginWait(email, ip string) time.Duration {
+	now := time.Now()
+
+	wait := app.emailLimiter.wait(email, now)
+	if ipWait := app.ipLimiter.wait(ip, now); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+func (app *application) loginFailed(email, ip string) {
+	now := time.Now()
+
+	if app.emailLimiter.fail(email, now) {
+		app.logger.PrintInfo("account locked out after failed logins", map[string]string{
+			"email":        email,
+			"ip":           ip,
+			"locked_until": now.Add(app.config.login.lockout).Format(time.RFC3339),
+		})
+	}
+
+	if app.ipLimiter.fail(ip, now) {
+		app.logger.PrintInfo("ip address locked out after failed logins", map[string]string{
+			"ip":           ip,
+			"locked_until": now.Add(app.config.login.lockout).Format(time.RFC3339),
+		})
+	}
+}
+
+// writeAuthenticationTokens issues a new authentication and refresh token pair
+// in the given token family and sends both to the client. In JWT mode the
+// authentication token is a signed JWT and only the refresh token is stored.
+// Without a family the tokens start a new session, which is audited as a login.
+func (app *application) writeAuthenticationTokens(w http.ResponseWriter, r *http.Request, user *data.User, family []byte, status int) {
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.NewPair(user.ID, app.storedAccessTTL(), app.config.auth.refreshTTL, family, app.userAgent(r), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if family == nil {
+		app.audit(app.contextSetUser(r, user), data.AuditLogin, data.AuditEntityUser, user.ID, nil, nil)
+	}
+
+	app.writeTokenPair(w, r, user, access, refresh, status)
+}
+
+// storedAccessTTL is the lifetime of the authentication tokens stored in the
+// database, zero in JWT mode where none are stored.
+func (app *application) storedAccessTTL() time.Duration {
+	if app.jwt != nil {
+		return 0
+	}
+	return app.config.auth.accessTTL
+}
+
+// writeTokenPair sends a freshly issued token pair to the client, signing the
+// authentication token first in JWT mode.
+func (app *application) writeTokenPair(w http.ResponseWriter, r *http.Request, user *data.User, access, refresh *data.Token, status int) {
+	var err error
+
+	if app.jwt != nil {
+		access, err = app.newAccessJWT(user, refresh.Family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, status, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Refresh Token
+//	@Description	Exchange a refresh token for a new authentication and refresh token pair
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputRefreshToken	true	"Refresh token"
+//	@Success		200		{object}	data.Token
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/tokens/refresh [post]
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputRefreshToken
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.Get(data.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if token.RotatedAt != nil {
+		app.refreshTokenReused(w, r, token)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(int(token.UserID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.Rotate(token, app.storedAccessTTL(), app.config.auth.refreshTTL, app.userAgent(r), app.clientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.refreshTokenReused(w, r, token)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeTokenPair(w, r, user, access, refresh, http.StatusOK)
+}
+
+// refreshTokenReused handles a refresh token which has already been rotated.
+// A refresh token is only ever handed out once, so seeing it again means it
+// has leaked. The whole session is revoked to lock out whoever else holds it.
+// In JWT mode the denylist can only revoke the access tokens of a user as a
+// whole, so all of them are denied.
+func (app *application) refreshTokenReused(w http.ResponseWriter, r *http.Request, token *data.Token) {
+	app.logger.PrintInfo("refresh token reuse detected, revoking token family", map[string]string{
+		"user_id": strconv.FormatInt(token.UserID, 10),
+		"ip":      app.clientIP(r),
+	})
+
+	var err error
+	if app.jwt != nil {
+		err = app.revokeAllTokens(token.UserID)
+	} else {
+		err = app.models.Tokens.DeleteFamily(token.Family)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.invalidRefreshTokenResponse(w, r)
+}
+
+//	@Summary		Password Reset Token
+//	@Description	Email a one-time password reset token to the user
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		data.InputPasswordResetToken	true	"Account email"
+//	@Success		202		{object}	string
+//	@Failure		400		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/tokens/password-reset [post]
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.InputPasswordResetToken
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The response is the same whether or not the account exists, so that this
+	// endpoint can't be used to find out which emails are registered.
+	env := envelope{"message": "if an account with this email address exists, you will receive password reset instructions shortly"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The token is created in the background along with the email, so that
+	// known emails don't take measurably longer to answer than unknown ones.
+	app.background(func() {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Logout
+//	@Description	Revoke the authentication token used for this request
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/tokens/authentication [delete]
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		err = app.revokeAccessJWT(claims)
+		if err == nil && claims.family() != nil {
+			err = app.models.Tokens.DeleteFamily(claims.family())
+		}
+	} else {
+		err = app.models.Tokens.DeleteForToken(data.ScopeAuthentication, app.contextGetToken(r))
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Logout Everywhere
+//	@Description	Revoke every authentication token of the current user
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	string
+//	@Failure		401	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/tokens/authentication/all [delete]
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllTokens(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		List Sessions
+//	@Description	Active sessions of the current user
+//	@Security		ApiKeyAuth
+//	@Tags			User
+//	@Produce		json
+//	@Success		200	{object}	[]data.Session
+//	@Failure		401	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/users/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetAllForUser(user.ID, app.contextGetToken(r), app.contextGetClaims(r).family())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//	@Summary		List Deleted Products
+//	@Description	Products in the trash: the seller's own, or everyone's for users with the products:manage permission
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			page		query		int		false	"page"
+//	@Param			page_size	query		int		false	"Page size"
+//	@Param			sort		query		string	false	"sort"
+//	@Success		200			{object}	[]data.Product
+//	@Failure		401			{object}	Error
+//	@Failure		403			{object}	Error
+//	@Failure		422			{object}	Error
+//	@Failure		500			{object}	Error
+//	@Router			/products/trash [get]
+func (app *application) listDeletedProductsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.hasPermission(r, data.PermissionProductsManage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+	if ok {
+		userID = 0
+	}
+
+	products, metadata, err := app.models.Products.GetAllDeleted(userID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"products": products, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Restore Product
+//	@Description	Take a deleted product out of the trash
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			id	path		int	true	"Product ID"
+//	@Success		200	{object}	data.Product
+//	@Failure		401	{object}	Error
+//	@Failure		403	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/products/{id}/restore [post]
+func (app *application) restoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	product, err := app.models.Products.GetDeleted(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	err = app.models.Products.Restore(product)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditProductRestore, data.AuditEntityProduct, product.ID, nil, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"product": product}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeDeletedProducts hourly removes the products that have been in the trash
+// for longer than the retention period, and the images uploaded for them,
+// until ctx is cancelled.
+func (app *application) purgeDeletedProducts(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			products, err := app.models.Products.PurgeDeleted(ctx, now.Add(-app.config.products.retention))
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					app.logger.PrintError(err, nil)
+				}
+				continue
+			}
+
+			for _, product := range products {
+				for _, image := range product.Images {
+					if key, ok := productImageKey(product.ID, image); ok {
+						app.deleteImage(key)
+					}
+				}
+			}
+
+			if len(products) > 0 {
+				app.logger.PrintInfo("purged deleted products", map[string]string{
+					"count": strconv.Itoa(len(products)),
+				})
+			}
+		}
+	}
+}
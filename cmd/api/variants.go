@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jumagaliev1/internal/data"
+	"github.com/jumagaliev1/internal/validator"
+	"net/http"
+)
+
+// readProductParam loads the product of a /v1/products/:id/... route, writing
+// a 404 or 500 and returning false when that fails.
+func (app *application) readProductParam(w http.ResponseWriter, r *http.Request) (*data.Product, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	product, err := app.models.Products.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return product, true
+}
+
+// readVariantParam loads the variant of a /v1/products/:id/variants/:variant_id
+// route. Variants of other products are not found.
+func (app *application) readVariantParam(w http.ResponseWriter, r *http.Request, product *data.Product) (*data.Variant, bool) {
+	id, err := app.readNamedIDParam(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	variant, err := app.models.Variants.Get(id)
+	if err != nil || variant.ProductID != product.ID {
+		switch {
+		case err == nil, errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return variant, true
+}
+
+//	@Summary		List Variants
+//	@Description	Variants of a product
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			id	path		int	true	"Product ID"
+//	@Success		200	{object}	[]data.Variant
+//	@Failure		401	{object}	Error
+//	@Failure		404	{object}	Error
+//	@Failure		500	{object}	Error
+//	@Router			/products/{id}/variants [get]
+func (app *application) listVariantsHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	variants, err := app.models.Variants.GetAllForProduct(product.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variants": variants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Create Variant
+//	@Description	Add a variant, like a size or colour, to a product
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int						true	"Product ID"
+//	@Param			input	body		data.InputCreateVariant	true	"Input"
+//	@Success		201		{object}	data.Variant
+//	@Failure		400		{object}	Error
+//	@Failure		401		{object}	Error
+//	@Failure		403		{object}	Error
+//	@Failure		404		{object}	Error
+//	@Failure		422		{object}	Error
+//	@Failure		500		{object}	Error
+//	@Router			/products/{id}/variants [post]
+func (app *application) createVariantHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	input := &data.InputCreateVariant{}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	variant := &data.Variant{
+		ProductID: product.ID,
+		SKU:       input.SKU,
+		Options:   input.Options,
+		Price:     input.Price,
+		Stock:     input.Stock,
+		Images:    input.Images,
+	}
+	if variant.Options == nil {
+		variant.Options = map[string]string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Insert(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSKU):
+			v.AddError("sku", "a variant with this SKU already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/products/%d/variants/%d", product.ID, variant.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"variant": variant}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Update Variant
+//	@Description	Update a variant of a product
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"Product ID"
+//	@Param			variant_id	path		int						true	"Variant ID"
+//	@Param			input		body		data.InputUpdateVariant	true	"Input"
+//	@Success		200			{object}	data.Variant
+//	@Failure		400			{object}	Error
+//	@Failure		401			{object}	Error
+//	@Failure		403			{object}	Error
+//	@Failure		404			{object}	Error
+//	@Failure		409			{object}	Error
+//	@Failure		422			{object}	Error
+//	@Failure		500			{object}	Error
+//	@Router			/products/{id}/variants/{variant_id} [patch]
+func (app *application) updateVariantHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	variant, ok := app.readVariantParam(w, r, product)
+	if !ok {
+		return
+	}
+
+	input := &data.InputUpdateVariant{}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != variant.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.SKU != nil {
+		variant.SKU = *input.SKU
+	}
+
+	if input.Options != nil {
+		variant.Options = input.Options
+	}
+
+	if input.Price != nil {
+		variant.Price = *input.Price
+	}
+
+	if input.Stock != nil {
+		variant.Stock = *input.Stock
+	}
+
+	if input.Images != nil {
+		variant.Images = input.Images
+	}
+
+	v := validator.New()
+
+	if data.ValidateVariant(v, variant); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Variants.Update(variant)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateSKU):
+			v.AddError("sku", "a variant with this SKU already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"variant": variant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+//	@Summary		Delete Variant
+//	@Description	Delete a variant of a product. The last variant of a product can't be deleted
+//	@Security		ApiKeyAuth
+//	@Tags			Product
+//	@Produce		json
+//	@Param			id			path		int	true	"Product ID"
+//	@Param			variant_id	path		int	true	"Variant ID"
+//	@Success		200			{object}	string
+//	@Failure		401			{object}	Error
+//	@Failure		403			{object}	Error
+//	@Failure		404			{object}	Error
+//	@Failure		409			{object}	Error
+//	@Failure		500			{object}	Error
+//	@Router			/products/{id}/variants/{variant_id} [delete]
+func (app *application) deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	product, ok := app.readProductParam(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canManageProduct(w, r, product) {
+		return
+	}
+
+	id, err := app.readNamedIDParam(r, "variant_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Variants.Delete(id, product.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLastVariant):
+			app.errorResponse(w, r, http.StatusConflict, "the last variant of a product can't be deleted")
+		case errors.Is(err, data.ErrVariantOrdered):
+			app.errorResponse(w, r, http.StatusConflict, "a variant which has been ordered can't be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "variant successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,331 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jumagaliev1/internal/validator"
+	"github.com/lib/pq"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+	AttributeEnum    = "enum"
+)
+
+var AttributeTypes = []string{AttributeString, AttributeNumber, AttributeBoolean, AttributeEnum}
+
+var AttributeCodeRX = regexp.MustCompile("^[a-z][a-z0-9_]{0,49}$")
+
+var ErrDuplicateAttribute = errors.New("duplicate attribute")
+
+// Attribute defines a property, like brand or RAM size, that products of a
+// category and its subcategories can have. Where a subcategory defines a code
+// of its own, that definition wins.
+type Attribute struct {
+	ID         int64     `json:"id"`
+	CategoryID int64     `json:"category_id"`
+	Code       string    `json:"code"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Options    []string  `json:"options,omitempty"`
+	CreatedAt  time.Time `json:"-"`
+}
+
+// AttributeValue is the value of an attribute for a product. Text holds every
+// value in its canonical form; Number is only set for number attributes.
+type AttributeValue struct {
+	AttributeID int64
+	Text        string
+	Number      *float64
+}
+
+// AttributeFilter narrows a product listing down to the products whose
+// attribute has one of the Values (operator eq) or compares to the single value
+// (operators gt, gte, lt and lte, for number attributes).
+type AttributeFilter struct {
+	Code     string
+	Operator string
+	Values   []string
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+var attributeOperators = map[string]string{
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+var AttributeOperators = []string{"eq", "gt", "gte", "lt", "lte"}
+
+func ValidateAttribute(v *validator.Validator, attribute *Attribute) {
+	v.Check(validator.Matches(attribute.Code, AttributeCodeRX), "code", "must start with a lowercase letter and only contain lowercase letters, digits and underscores, up to 50 characters")
+
+	v.Check(attribute.Name != "", "name", "must be provided")
+	v.Check(len(attribute.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(validator.In(attribute.Type, AttributeTypes...), "type", "must be string, number, boolean or enum")
+
+	if attribute.Type == AttributeEnum {
+		v.Check(len(attribute.Options) > 0, "options", "must be provided for enum attributes")
+		v.Check(len(attribute.Options) <= 100, "options", "must not contain more than 100 values")
+		v.Check(validator.Unique(attribute.Options), "options", "must not contain duplicate values")
+		for _, option := range attribute.Options {
+			v.Check(option != "" && len(option) <= 100, "options", "must contain values of 1 to 100 bytes")
+		}
+	} else {
+		v.Check(len(attribute.Options) == 0, "options", "must only be provided for enum attributes")
+	}
+}
+
+func ValidateAttributeFilter(v *validator.Validator, filter AttributeFilter) {
+	key := "attr." + filter.Code
+
+	v.Check(validator.Matches(filter.Code, AttributeCodeRX), key, "must be a valid attribute code")
+	v.Check(validator.In(filter.Operator, AttributeOperators...), key, "must use one of the operators eq, gt, gte, lt or lte")
+	v.Check(len(filter.Values) > 0, key, "must have a value")
+
+	if _, ok := attributeOperators[filter.Operator]; ok {
+		v.Check(len(filter.Values) == 1, key, "must have a single value for range operators")
+		for _, value := range filter.Values {
+			_, err := strconv.ParseFloat(value, 64)
+			v.Check(err == nil, key, "must be a number for range operators")
+		}
+	}
+}
+
+// Value checks a value decoded from JSON against the attribute and returns it
+// in its stored form. It returns false when the value doesn't fit the type.
+func (a *Attribute) Value(value interface{}) (AttributeValue, bool) {
+	result := AttributeValue{AttributeID: a.ID}
+
+	switch a.Type {
+	case AttributeString:
+		s, ok := value.(string)
+		if !ok || s == "" || len(s) > 200 {
+			return result, false
+		}
+		result.Text = s
+	case AttributeEnum:
+		s, ok := value.(string)
+		if !ok || !validator.In(s, a.Options...) {
+			return result, false
+		}
+		result.Text = s
+	case AttributeNumber:
+		f, ok := value.(float64)
+		if !ok {
+			return result, false
+		}
+		result.Text = strconv.FormatFloat(f, 'f', -1, 64)
+		result.Number = &f
+	case AttributeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return result, false
+		}
+		result.Text = strconv.FormatBool(b)
+	default:
+		return result, false
+	}
+
+	return result, true
+}
+
+// decode turns a stored value back into its JSON type.
+func (a *Attribute) decode(text string, number *float64) interface{} {
+	switch a.Type {
+	case AttributeNumber:
+		if number != nil {
+			return *number
+		}
+	case AttributeBoolean:
+		return text == "true"
+	}
+	return text
+}
+
+type AttributeModel struct {
+	DB *sql.DB
+}
+
+func (m AttributeModel) Insert(attribute *Attribute) error {
+	query := `
+			INSERT INTO attributes (category_id, code, name, type, options)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at`
+
+	args := []interface{}{attribute.CategoryID, attribute.Code, attribute.Name, attribute.Type, pq.Array(attribute.Options)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attribute.ID, &attribute.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "attributes_category_id_code_key"`:
+			return ErrDuplicateAttribute
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForCategory returns the attributes products of the category can have:
+// its own and those of its ancestors, ordered by code.
+func (m AttributeModel) GetAllForCategory(categoryID int64) ([]*Attribute, error) {
+	query := `
+			WITH RECURSIVE ancestors AS (
+				SELECT id, parent_id, 0 AS depth FROM categories WHERE id = $1
+				UNION
+				SELECT categories.id, categories.parent_id, ancestors.depth + 1
+				FROM categories INNER JOIN ancestors ON categories.id = ancestors.parent_id
+			)
+			SELECT DISTINCT ON (attributes.code)
+			       attributes.id, attributes.category_id, attributes.code, attributes.name, attributes.type, attributes.options, attributes.created_at
+			FROM attributes
+			INNER JOIN ancestors ON ancestors.id = attributes.category_id
+			ORDER BY attributes.code, ancestors.depth`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := []*Attribute{}
+
+	for rows.Next() {
+		var attribute Attribute
+
+		err := rows.Scan(
+			&attribute.ID,
+			&attribute.CategoryID,
+			&attribute.Code,
+			&attribute.Name,
+			&attribute.Type,
+			pq.Array(&attribute.Options),
+			&attribute.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes = append(attributes, &attribute)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+// Delete removes an attribute defined by the given category, together with the
+// values products have for it.
+func (m AttributeModel) Delete(id, categoryID int64) error {
+	query := `
+			DELETE FROM attributes
+			WHERE id = $1 AND category_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, categoryID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForProduct returns the attribute values of a product by code.
+func (m AttributeModel) GetForProduct(productID int64) (map[string]interface{}, error) {
+	query := `
+			SELECT attributes.code, attributes.type, product_attributes.value_text, product_attributes.value_number
+			FROM product_attributes
+			INNER JOIN attributes ON attributes.id = product_attributes.attribute_id
+			WHERE product_attributes.product_id = $1
+			ORDER BY attributes.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]interface{})
+
+	for rows.Next() {
+		var attribute Attribute
+		var text string
+		var number *float64
+
+		err := rows.Scan(&attribute.Code, &attribute.Type, &text, &number)
+		if err != nil {
+			return nil, err
+		}
+
+		values[attribute.Code] = attribute.decode(text, number)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// SetForProduct replaces the attribute values of a product.
+func (m AttributeModel) SetForProduct(productID int64, values []AttributeValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_attributes WHERE product_id = $1`, productID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO product_attributes (product_id, attribute_id, value_text, value_number)
+			VALUES ($1, $2, $3, $4)`
+
+	for _, value := range values {
+		_, err = tx.ExecContext(ctx, query, productID, value.AttributeID, value.Text, value.Number)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	AuditLogin           = "user.login"
+	AuditLoginFailed     = "user.login_failed"
+	AuditPasswordReset   = "user.password_reset"
+	AuditUserDelete      = "user.delete"
+	AuditUserUpdate      = "user.update"
+	AuditUserUnlock      = "user.unlock"
+	AuditUserImpersonate = "user.impersonate"
+	AuditAPIKeyCreate    = "api_key.create"
+	AuditAPIKeyRevoke    = "api_key.revoke"
+	AuditProductUpdate   = "product.update"
+	AuditProductDelete   = "product.delete"
+	AuditProductRestore  = "product.restore"
+	AuditOrderCreate     = "order.create"
+	AuditOrderApprove    = "order.approve"
+	AuditOrderCancel     = "order.cancel"
+)
+
+const (
+	AuditEntityUser    = "user"
+	AuditEntityAPIKey  = "api_key"
+	AuditEntityProduct = "product"
+	AuditEntityOrder   = "order"
+)
+
+// auditChangesMaxFields guards against accidentally diffing a huge document.
+const auditChangesMaxFields = 100
+
+// AuditEvent records who did what to which entity. Changes holds the fields
+// that differ between the entity before and after the action.
+type AuditEvent struct {
+	ID         int64             `json:"id"`
+	ActorID    *int64            `json:"actor_id"`
+	Action     string            `json:"action"`
+	EntityType string            `json:"entity_type"`
+	EntityID   *int64            `json:"entity_id"`
+	Changes    map[string]Change `json:"changes"`
+	IP         string            `json:"ip"`
+	RequestID  string            `json:"request_id"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff compares the JSON representations of before and after, either of which
+// may be nil, and returns the fields that differ. Fields hidden from JSON, like
+// password hashes, never end up in the audit log.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = Change{Before: value, After: a[key]}
+		}
+	}
+	for key, value := range a {
+		if _, ok := b[key]; !ok {
+			changes[key] = Change{After: value}
+		}
+	}
+
+	if len(changes) > auditChangesMaxFields {
+		return nil, fmt.Errorf("audit: too many changed fields (%d)", len(changes))
+	}
+
+	return changes, nil
+}
+
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(event *AuditEvent) error {
+	changes, err := json.Marshal(event.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO audit_events (actor_id, action, entity_type, entity_id, changes, ip, request_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, created_at`
+
+	args := []interface{}{event.ActorID, event.Action, event.EntityType, event.EntityID, changes, event.IP, event.RequestID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (m AuditModel) GetAll(input InputListAuditEvents) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), id, actor_id, action, entity_type, entity_id, changes, ip, request_id, created_at
+			FROM audit_events
+			WHERE (created_at >= $1::timestamptz OR $1::timestamptz IS NULL)
+			AND (created_at < $2::timestamptz OR $2::timestamptz IS NULL)
+			AND (actor_id = $3 OR $3 = 0)
+			AND (action = $4 OR $4 = '')
+			AND (entity_type = $5 OR $5 = '')
+			AND (entity_id = $6 OR $6 = 0)
+			ORDER BY %s %s, id ASC
+			LIMIT $7 OFFSET $8`, input.Filters.sortColumn(), input.Filters.sortDirection())
+
+	args := []interface{}{
+		input.From,
+		input.To,
+		input.ActorID,
+		input.Action,
+		input.EntityType,
+		input.EntityID,
+		input.Filters.limit(),
+		input.Filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.ActorID,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&changes,
+			&event.IP,
+			&event.RequestID,
+			&event.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(changes, &event.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, input.Filters.Page, input.Filters.PageSize)
+
+	return events, metadata, nil
+}
@@ -12,12 +12,16 @@ type Cart struct {
 	ID       int64   `json:"id"`
 	User     User    `json:"user"`
 	Product  Product `json:"product"`
+	Variant  Variant `json:"variant"`
 	Quantity int     `json:"quantity"`
 }
 
+// CartReq takes a variant. A bare product ID is still accepted for products
+// with a single variant.
 type CartReq struct {
-	ProductID int `json:"product_id"`
-	Quantity  int `json:"quantity"`
+	ProductID int   `json:"product_id"`
+	VariantID int64 `json:"variant_id"`
+	Quantity  int   `json:"quantity"`
 }
 
 func ValidateCart(v *validator.Validator, c *Cart) {
@@ -32,21 +36,25 @@ type CartModel struct {
 }
 
 func (m CartModel) Insert(cart *Cart) error {
-	query := `INSERT INTO carts (user_id, product_id, quantity) 
-				VALUES ($1, $2, $3)
+	query := `INSERT INTO carts (user_id, product_id, variant_id, quantity) 
+				VALUES ($1, $2, $3, $4)
 				RETURNING id`
 
-	args := []interface{}{cart.User.ID, cart.Product.ID, cart.Quantity}
+	args := []interface{}{cart.User.ID, cart.Product.ID, cart.Variant.ID, cart.Quantity}
 
 	return m.DB.QueryRow(query, args...).Scan(&cart.ID)
 }
 
+// GetByID returns a cart item. Items of products in the trash can't be ordered
+// and give ErrRecordNotFound.
 func (m CartModel) GetByID(ID int) (*Cart, error) {
 	query := `SELECT carts.id, carts.user_id, 
        carts.product_id, products.price,
+       carts.variant_id, product_variants.price,
        carts.quantity
 				FROM carts JOIN products ON products.id = carts.product_id
-				WHERE carts.id = $1`
+				JOIN product_variants ON product_variants.id = carts.variant_id
+				WHERE carts.id = $1 AND products.deleted_at IS NULL`
 
 	var cart Cart
 
@@ -58,6 +66,8 @@ func (m CartModel) GetByID(ID int) (*Cart, error) {
 		&cart.User.ID,
 		&cart.Product.ID,
 		&cart.Product.Price,
+		&cart.Variant.ID,
+		&cart.Variant.Price,
 		&cart.Quantity)
 
 	if err != nil {
@@ -72,7 +82,7 @@ func (m CartModel) GetByID(ID int) (*Cart, error) {
 	return &cart, nil
 }
 func (m CartModel) GetByUser(user *User) (*Cart, error) {
-	query := `SELECT id, user_id, product_id, quantity 
+	query := `SELECT id, user_id, product_id, variant_id, quantity 
 				FROM carts
 				WHERE user_id = $1`
 
@@ -85,6 +95,7 @@ func (m CartModel) GetByUser(user *User) (*Cart, error) {
 		&cart.ID,
 		&cart.User.ID,
 		&cart.Product.ID,
+		&cart.Variant.ID,
 		&cart.Quantity)
 
 	if err != nil {
@@ -98,3 +109,42 @@ func (m CartModel) GetByUser(user *User) (*Cart, error) {
 
 	return &cart, nil
 }
+
+func (m CartModel) GetAllForUser(userID int64) ([]*Cart, error) {
+	query := `SELECT id, user_id, product_id, variant_id, quantity
+				FROM carts
+				WHERE user_id = $1
+				ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	carts := []*Cart{}
+
+	for rows.Next() {
+		var cart Cart
+
+		err := rows.Scan(
+			&cart.ID,
+			&cart.User.ID,
+			&cart.Product.ID,
+			&cart.Variant.ID,
+			&cart.Quantity)
+		if err != nil {
+			return nil, err
+		}
+
+		carts = append(carts, &cart)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
@@ -0,0 +1,347 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jumagaliev1/internal/validator"
+	"github.com/lib/pq"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	ErrCategoryInUse = errors.New("category in use")
+)
+
+var SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+
+// Category is a node of the category tree. Top level categories have no parent.
+type Category struct {
+	ID        int64       `json:"id"`
+	ParentID  *int64      `json:"parent_id"`
+	Title     string      `json:"title"`
+	Slug      string      `json:"slug"`
+	Version   int32       `json:"version"`
+	CreatedAt time.Time   `json:"-"`
+	Children  []*Category `json:"children,omitempty"`
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Title != "", "title", "must be provided")
+	v.Check(len(category.Title) <= 100, "title", "must not be more than 100 bytes long")
+
+	// A new category may go without a slug, Insert makes one of its ID then.
+	if category.ID != 0 || category.Slug != "" {
+		v.Check(category.Slug != "", "slug", "must be provided")
+		v.Check(len(category.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+		v.Check(validator.Matches(category.Slug, SlugRX), "slug", "must only contain lowercase letters, digits and single hyphens")
+	}
+
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parent_id", "must be a positive integer")
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+// translit spells Cyrillic letters, Kazakh ones included, in Latin for slugs.
+// Hard and soft signs have no sound of their own and are dropped.
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'ә': "a", 'ғ': "g", 'қ': "q", 'ң': "ng", 'ө': "o", 'ұ': "u", 'ү': "u",
+	'һ': "h", 'і': "i",
+}
+
+// Slugify derives a slug from a title, for categories created without one.
+// Cyrillic is transliterated. A title with nothing to spell a slug with gives
+// an empty one, which Insert replaces with one made of the ID.
+func Slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(title) {
+		if latin, ok := translit[r]; ok {
+			b.WriteString(latin)
+			hyphen = false
+		} else if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// BuildCategoryTree nests the categories under their parents and returns the
+// top level ones. Children keep the order of the input.
+func BuildCategoryTree(categories []*Category) []*Category {
+	byID := make(map[int64]*Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+
+	roots := []*Category{}
+	for _, category := range categories {
+		if category.ParentID != nil {
+			if parent, ok := byID[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, category)
+				continue
+			}
+		}
+		roots = append(roots, category)
+	}
+
+	return roots
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+// Insert adds the category. One without a slug is given "category-<id>", as
+// the categories which had none were when slugs were introduced.
+func (m CategoryModel) Insert(category *Category) error {
+	query := `
+			WITH next AS (SELECT nextval(pg_get_serial_sequence('categories', 'id')) AS id)
+			INSERT INTO categories (id, parent_id, title, slug)
+			SELECT next.id, $1, $2, coalesce(nullif($3, ''), 'category-' || next.id)
+			FROM next
+			RETURNING id, slug, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, category.ParentID, category.Title, category.Slug).Scan(&category.ID, &category.Slug, &category.CreatedAt, &category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_idx"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, parent_id, title, slug, version, created_at
+			FROM categories
+			WHERE id = $1`
+
+	var category Category
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&category.ID,
+		&category.ParentID,
+		&category.Title,
+		&category.Slug,
+		&category.Version,
+		&category.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &category, nil
+}
+
+func (m CategoryModel) Exists(id int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists)
+	return exists, err
+}
+
+// GetAll returns every category, flat and ordered by title. Use
+// BuildCategoryTree to nest them.
+func (m CategoryModel) GetAll() ([]*Category, error) {
+	query := `
+			SELECT id, parent_id, title, slug, version, created_at
+			FROM categories
+			ORDER BY title, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+
+	for rows.Next() {
+		var category Category
+
+		err := rows.Scan(
+			&category.ID,
+			&category.ParentID,
+			&category.Title,
+			&category.Slug,
+			&category.Version,
+			&category.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// Suggest returns up to limit categories whose title matches the
+// search-as-you-type query q, like ProductModel.Suggest does for products.
+func (m CategoryModel) Suggest(q string, limit int) ([]*Category, error) {
+	query := `
+			SELECT id, parent_id, title, slug, version, created_at
+			FROM categories
+			WHERE lower(title) LIKE $2 OR title % $1
+			ORDER BY lower(title) LIKE $2 DESC, similarity(title, $1) DESC, title, id
+			LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, likePrefix(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+
+	for rows.Next() {
+		var category Category
+
+		err := rows.Scan(
+			&category.ID,
+			&category.ParentID,
+			&category.Title,
+			&category.Slug,
+			&category.Version,
+			&category.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, &category)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// IsDescendant reports whether the category with the given ID lies in the
+// subtree of ancestorID, the ancestor itself included.
+func (m CategoryModel) IsDescendant(id, ancestorID int64) (bool, error) {
+	query := `
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE id = $2
+				UNION
+				SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+			)
+			SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var descendant bool
+	err := m.DB.QueryRowContext(ctx, query, id, ancestorID).Scan(&descendant)
+	return descendant, err
+}
+
+func (m CategoryModel) Update(category *Category) error {
+	query := `
+			UPDATE categories
+			SET parent_id = $1, title = $2, slug = $3, version = version + 1
+			WHERE id = $4 AND version = $5
+			RETURNING version`
+
+	args := []interface{}{category.ParentID, category.Title, category.Slug, category.ID, category.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_idx"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a category. Categories that still have subcategories or
+// products can't be deleted and give ErrCategoryInUse.
+func (m CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM categories
+			WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrCategoryInUse
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
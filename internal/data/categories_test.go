@@ -0,0 +1,51 @@
+package data
+
+import (
+	"github.com/jumagaliev1/internal/validator"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "Home & Garden", want: "home-garden"},
+		{title: "  TVs 4K  ", want: "tvs-4k"},
+		{title: "Детские товары", want: "detskie-tovary"},
+		{title: "Объявления", want: "obyavleniya"},
+		{title: "Қазақша өнімдер", want: "qazaqsha-onimder"},
+		{title: "Ұлттық киім", want: "ulttyq-kiim"},
+		{title: "★★★", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := Slugify(tt.title)
+		if got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+		if got != "" && !validator.Matches(got, SlugRX) {
+			t.Errorf("Slugify(%q) = %q, which isn't a valid slug", tt.title, got)
+		}
+	}
+}
+
+func TestValidateCategorySlug(t *testing.T) {
+	tests := []struct {
+		name      string
+		category  Category
+		wantValid bool
+	}{
+		{name: "new without slug", category: Category{Title: "★"}, wantValid: true},
+		{name: "existing without slug", category: Category{ID: 1, Title: "★"}},
+		{name: "invalid slug", category: Category{Title: "Food", Slug: "Food!"}},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateCategory(v, &tt.category)
+		if v.Valid() != tt.wantValid {
+			t.Errorf("%s: valid = %v, want %v (%v)", tt.name, v.Valid(), tt.wantValid, v.Errors)
+		}
+	}
+}
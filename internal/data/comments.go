@@ -71,14 +71,51 @@ func (m CommentModel) GetByProduct(p *Product) ([]Comment, error) {
 	}
 	for rows.Next() {
 		var comment Comment
+		var role int32
 		err := rows.Scan(&comment.ID,
-			&comment.User.ID, &comment.User.LastName, &comment.User.FirstName, &comment.User.Email, &comment.User.Phone, &comment.User.Address, &comment.User.Role, &comment.User.CreatedAt, &comment.User.UpdatedAt, &comment.User.DeletedAt,
+			&comment.User.ID, &comment.User.LastName, &comment.User.FirstName, &comment.User.Email, &comment.User.Phone, &comment.User.Address, &role, &comment.User.CreatedAt, &comment.User.UpdatedAt, &comment.User.DeletedAt,
 			&comment.Product.ID, &comment.Product.Category, &comment.Product.User, &comment.Product.Title, &comment.Product.Description, &comment.Product.Price, &comment.Product.Rating, &comment.Product.Stock, pq.Array(&comment.Product.Images), &comment.Product.CreatedAt, &comment.Product.UpdatedAt, &comment.Product.DeletedAt,
 			&comment.Message, &comment.Rating, &comment.CreatedAt, &comment.UpdatedAt, &comment.DeletedAt)
 		if err != nil {
 			return nil, err
 		}
+		comment.User.Role = Roles_name[role]
 		comments = append(comments, comment)
 	}
 	return comments, nil
 }
+
+func (m CommentModel) GetAllForUser(userID int64) ([]*Comment, error) {
+	query := `SELECT id, product_id, comment, rating, created_at, updated_at
+			FROM comments
+			WHERE user_id = $1
+			ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+
+		err := rows.Scan(&comment.ID, &comment.Product.ID, &comment.Message, &comment.Rating, &comment.CreatedAt, &comment.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		comment.User.ID = userID
+		comments = append(comments, &comment)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
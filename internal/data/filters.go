@@ -1,23 +1,55 @@
 package data
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jumagaliev1/internal/validator"
 	"math"
 	"strings"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Filters select a page of a listing, either by page number or, when Cursor is
+// set, by keyset: the rows after (or before) the sort key and id the cursor
+// holds. Cursors are signed with CursorKey so clients can't craft them.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
+	CursorKey    []byte
 }
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+
+	Facets map[string][]FacetValue `json:"facets,omitempty"`
+}
+
+// Cursor is the position a cursor points at: the sort key, as text, and id of
+// a row. Backward cursors ask for the page before the row instead of after it.
+type Cursor struct {
+	Sort     string `json:"s"`
+	Key      string `json:"k"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// cursorPosition is the sort key and id of a row read by a keyset query.
+type cursorPosition struct {
+	Key string
+	ID  int64
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -57,8 +89,124 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+func (f Filters) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, f.CursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (f Filters) encodeCursor(cursor Cursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(f.sign(payload))
+}
+
+// cursor checks and decodes the Cursor of the filters. It returns nil when
+// there is none, and ErrInvalidCursor for a cursor that wasn't signed with
+// CursorKey or was made for another sort order.
+func (f Filters) cursor() (*Cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	encodedPayload, encodedSignature, ok := strings.Cut(f.Cursor, ".")
+	if !ok || len(f.CursorKey) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, f.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(payload, &cursor)
+	if err != nil || cursor.Sort != f.Sort {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// keyset returns the condition selecting the rows past the cursor and the
+// ORDER BY clause to read them in, appending the cursor's key and id to args.
+// column is the SQL expression of the sort column; rows are ordered by it and
+// then by id. Without a cursor the condition is TRUE. Queries read one row
+// more than the page size and hand the positions of the rows read to
+// cursorPage.
+func (f Filters) keyset(column string, args []interface{}) (string, string, []interface{}) {
+	direction := f.sortDirection()
+	order := fmt.Sprintf("%s %s, id ASC", column, direction)
+
+	cursor, err := f.cursor()
+	if err != nil || cursor == nil {
+		return "TRUE", order, args
+	}
+
+	// Going forward, the rows wanted come after the cursor in the listing's
+	// order. Going backward they come before it and are read in reverse
+	// order, so the ones nearest to the cursor come first; cursorPage puts
+	// them back in order.
+	compare, compareID := ">", ">"
+	if (direction == "DESC") != cursor.Backward {
+		compare = "<"
+	}
+	if cursor.Backward {
+		compareID = "<"
+		reverse := "DESC"
+		if direction == "DESC" {
+			reverse = "ASC"
+		}
+		order = fmt.Sprintf("%s %s, id DESC", column, reverse)
+	}
+
+	args = append(args, cursor.Key, cursor.ID)
+	condition := fmt.Sprintf("(%[1]s %[2]s $%[4]d OR (%[1]s = $%[4]d AND id %[3]s $%[5]d))", column, compare, compareID, len(args)-1, len(args))
+
+	return condition, order, args
+}
+
+// cursorPage handles the rows read by a keyset query, given their positions in
+// the order read. It returns how many of them make up the page and whether
+// they have to be reversed, and sets the next and previous cursors of the
+// metadata.
+func (f Filters) cursorPage(metadata *Metadata, positions []cursorPosition) (int, bool) {
+	cursor, _ := f.cursor()
+	backward := cursor != nil && cursor.Backward
+
+	more := len(positions) > f.limit()
+	if more {
+		positions = positions[:f.limit()]
+	}
+	if len(positions) == 0 {
+		return 0, backward
+	}
+
+	first, last := positions[0], positions[len(positions)-1]
+	if backward {
+		first, last = last, first
+	}
+
+	if more && !backward || backward {
+		metadata.NextCursor = f.encodeCursor(Cursor{Sort: f.Sort, Key: last.Key, ID: last.ID})
+	}
+	if more && backward || cursor != nil && !backward || cursor == nil && f.Page > 1 {
+		metadata.PrevCursor = f.encodeCursor(Cursor{Sort: f.Sort, Key: first.Key, ID: first.ID, Backward: true})
+	}
+
+	return len(positions), backward
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
@@ -66,4 +214,7 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	_, err := f.cursor()
+	v.Check(err == nil, "cursor", "must be a cursor from the metadata of a listing with the same sort")
 }
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links an account at an external OpenID Connect provider to a user.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin is a login in progress, kept between sending the user to the
+// provider and the provider sending them back.
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m IdentityModel) Insert(identity *Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertIdentity(ctx, m.DB, identity)
+}
+
+func insertIdentity(ctx context.Context, db queryer, identity *Identity) error {
+	query := `
+			INSERT INTO user_identities (user_id, provider, subject, email)
+			VALUES ($1, $2, $3, NULLIF($4, ''))
+			RETURNING id, created_at`
+
+	args := []interface{}{identity.UserID, identity.Provider, identity.Subject, identity.Email}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+}
+
+// GetUser returns the user the identity at provider is linked to.
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+			SELECT user_id
+			FROM user_identities
+			INNER JOIN users ON users.id = user_identities.user_id
+			WHERE user_identities.provider = $1
+			AND user_identities.subject = $2
+			AND users.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return UserModel{DB: m.DB}.GetByID(int(userID))
+}
+
+func (m IdentityModel) InsertLogin(login *OIDCLogin) error {
+	stateHash := sha256.Sum256([]byte(login.State))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Abandoned logins are cleaned up whenever a new one starts.
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < $1`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expiry)
+			VALUES ($1, $2, $3, $4, $5)`
+
+	args := []interface{}{stateHash[:], login.Provider, login.Nonce, login.CodeVerifier, login.Expiry}
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeLogin looks up the login with the given state and deletes it, so that
+// every state can only be used once.
+func (m IdentityModel) ConsumeLogin(provider, state string) (*OIDCLogin, error) {
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `
+			DELETE FROM oidc_logins
+			WHERE state_hash = $1 AND provider = $2 AND expiry > $3
+			RETURNING nonce, code_verifier, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	login := OIDCLogin{State: state, Provider: provider}
+
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:], provider, time.Now()).Scan(&login.Nonce, &login.CodeVerifier, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
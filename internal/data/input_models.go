@@ -1,5 +1,7 @@
 package data
 
+import "time"
+
 type InputCreateProduct struct {
 	Title       string   `json:"title"`
 	Description string   `json:"description"`
@@ -10,8 +12,73 @@ type InputCreateProduct struct {
 }
 
 type InputListProducts struct {
-	Title    string
-	Category int
+	Title         string
+	Category      int
+	MinPrice      int
+	MaxPrice      int
+	InStock       bool
+	MinRating     float64
+	Seller        int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Attributes    []AttributeFilter
+	Facets        []string
+	Filters
+}
+
+type InputCreateCategory struct {
+	ParentID *int64 `json:"parent_id"`
+	Title    string `json:"title"`
+	Slug     string `json:"slug"`
+}
+
+// InputUpdateCategory moves a category to the top level when ParentID is 0.
+type InputUpdateCategory struct {
+	ParentID *int64  `json:"parent_id"`
+	Title    *string `json:"title"`
+	Slug     *string `json:"slug"`
+	Version  *int32  `json:"version"`
+}
+
+type InputCreateAttribute struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Options []string `json:"options"`
+}
+
+type InputCreateVariant struct {
+	SKU     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   int               `json:"price"`
+	Stock   int               `json:"stock"`
+	Images  []string          `json:"images"`
+}
+
+type InputUpdateVariant struct {
+	SKU     *string           `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   *int              `json:"price"`
+	Stock   *int              `json:"stock"`
+	Images  []string          `json:"images"`
+	Version *int32            `json:"version"`
+}
+
+type InputListUsers struct {
+	Role        string
+	Email       string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Filters
+}
+
+type InputListAuditEvents struct {
+	From       *time.Time
+	To         *time.Time
+	ActorID    int64
+	Action     string
+	EntityType string
+	EntityID   int64
 	Filters
 }
 
@@ -35,3 +102,63 @@ type InputUpdateProduct struct {
 	Stock       *int     `json:"stock"`
 	Images      []string `json:"images"`
 }
+
+type InputActivateUser struct {
+	TokenPlaintext string `json:"token"`
+}
+
+type InputPasswordResetToken struct {
+	Email string `json:"email"`
+}
+
+type InputResetPassword struct {
+	Password       string `json:"password"`
+	TokenPlaintext string `json:"token"`
+}
+
+type InputRefreshToken struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type InputTOTPCode struct {
+	Code string `json:"code"`
+}
+
+type InputMFAChallenge struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type InputUpdateUser struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Phone     *string `json:"phone"`
+	Address   *string `json:"address"`
+	Version   *int32  `json:"version"`
+}
+
+type InputUpdateEmail struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type InputVerifyEmail struct {
+	TokenPlaintext string `json:"token"`
+}
+
+type InputDeleteAccount struct {
+	Password string `json:"password"`
+}
+
+type InputAdminUpdateUser struct {
+	Role      *string `json:"role"`
+	Suspended *bool   `json:"suspended"`
+	Version   *int32  `json:"version"`
+}
+
+type InputCreateAPIKey struct {
+	Name   string     `json:"name"`
+	Scopes []string   `json:"scopes"`
+	Expiry *time.Time `json:"expiry"`
+}
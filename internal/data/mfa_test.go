@@ -0,0 +1,22 @@
+package data
+
+import "testing"
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "abcd2345-efgh6723", want: "abcd2345efgh6723"},
+		{code: "ABCD2345-EFGH6723", want: "abcd2345efgh6723"},
+		{code: "  abcd2345-efgh6723\n", want: "abcd2345efgh6723"},
+		{code: "abcd2345efgh6723", want: "abcd2345efgh6723"},
+		{code: "ab-cd-2345-efgh-6723", want: "abcd2345efgh6723"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -11,21 +11,39 @@ var (
 )
 
 type Models struct {
-	Products ProductModel
-	Users    UserModel
-	Carts    CartModel
-	Orders   OrderModel
-	Comments CommentModel
-	Tokens   TokenModel
+	Products    ProductModel
+	Users       UserModel
+	Carts       CartModel
+	Orders      OrderModel
+	Comments    CommentModel
+	Tokens      TokenModel
+	Revocations RevocationModel
+	MFA         MFAModel
+	Permissions PermissionModel
+	Identities  IdentityModel
+	APIKeys     APIKeyModel
+	Audit       AuditModel
+	Categories  CategoryModel
+	Variants    VariantModel
+	Attributes  AttributeModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Products: ProductModel{DB: db},
-		Users:    UserModel{DB: db},
-		Carts:    CartModel{DB: db},
-		Orders:   OrderModel{DB: db},
-		Comments: CommentModel{DB: db},
-		Tokens:   TokenModel{DB: db},
+		Products:    ProductModel{DB: db},
+		Users:       UserModel{DB: db},
+		Carts:       CartModel{DB: db},
+		Orders:      OrderModel{DB: db},
+		Comments:    CommentModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Revocations: RevocationModel{DB: db},
+		MFA:         MFAModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		Identities:  IdentityModel{DB: db},
+		APIKeys:     APIKeyModel{DB: db},
+		Audit:       AuditModel{DB: db},
+		Categories:  CategoryModel{DB: db},
+		Variants:    VariantModel{DB: db},
+		Attributes:  AttributeModel{DB: db},
 	}
 }
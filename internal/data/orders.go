@@ -18,6 +18,7 @@ type Order struct {
 	ID          int64      `json:"id"`
 	OrderStatus string     `json:"order_status"`
 	Cart        Cart       `json:"cart_id"`
+	VariantID   int64      `json:"variant_id"`
 	Quantity    int        `json:"quantity"`
 	TotalPrice  int        `json:"total_price"`
 	CreatedAt   time.Time  `json:"created_at"`
@@ -39,18 +40,25 @@ type OrderModel struct {
 
 func (m OrderModel) Insert(order *Order) error {
 	query := `
-			INSERT INTO orders (cart_id, quantity, total_price)
-			VALUES ($1, $2, $3)
+			INSERT INTO orders (cart_id, variant_id, quantity, total_price)
+			VALUES ($1, $2, $3, $4)
 			RETURNING id`
 
-	args := []interface{}{order.Cart.ID, order.Quantity, order.TotalPrice}
+	args := []interface{}{order.Cart.ID, order.VariantID, order.Quantity, order.TotalPrice}
 
 	return m.DB.QueryRow(query, args...).Scan(&order.ID)
 }
+
+// GetByID returns the order along with the buyer of its cart and the seller of
+// the product, which are needed to tell who may act on it.
 func (m OrderModel) GetByID(ID int) (*Order, error) {
-	query := `SELECT id, cart_id, order_status, quantity, total_price, created_at, updated_at, deleted_at
-				FROM orders 
-				WHERE id = $1`
+	query := `
+			SELECT orders.id, orders.cart_id, carts.user_id, carts.product_id, products.user_id, orders.variant_id,
+			       orders.order_status, orders.quantity, orders.total_price, orders.created_at, orders.updated_at, orders.deleted_at
+			FROM orders
+			INNER JOIN carts ON carts.id = orders.cart_id
+			INNER JOIN products ON products.id = carts.product_id
+			WHERE orders.id = $1`
 
 	var order Order
 
@@ -60,6 +68,10 @@ func (m OrderModel) GetByID(ID int) (*Order, error) {
 	err := m.DB.QueryRowContext(ctx, query, ID).Scan(
 		&order.ID,
 		&order.Cart.ID,
+		&order.Cart.User.ID,
+		&order.Cart.Product.ID,
+		&order.Cart.Product.User,
+		&order.VariantID,
 		&order.OrderStatus,
 		&order.Quantity,
 		&order.TotalPrice,
@@ -78,11 +90,37 @@ func (m OrderModel) GetByID(ID int) (*Order, error) {
 
 	return &order, nil
 }
+
+// UpdateStatus moves the order from the from status to order.OrderStatus. It
+// returns ErrEditConflict if the order was no longer in the from status.
+func (m OrderModel) UpdateStatus(order *Order, from string) error {
+	query := `
+			UPDATE orders
+			SET order_status = $1, updated_at = now()
+			WHERE id = $2 AND order_status = $3
+			RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, order.OrderStatus, order.ID, from).Scan(&order.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m OrderModel) GetAll() ([]Order, error) {
 	var orders []Order
 
 	query := `
-			SELECT id, cart_id, order_status, quantity, total_price, created_at, updated_at, deleted_at 
+			SELECT id, cart_id, variant_id, order_status, quantity, total_price, created_at, updated_at, deleted_at 
 			FROM orders`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -98,6 +136,8 @@ func (m OrderModel) GetAll() ([]Order, error) {
 		err := rows.Scan(
 			&order.ID,
 			&order.Cart.ID,
+			&order.VariantID,
+			&order.OrderStatus,
 			&order.Quantity,
 			&order.TotalPrice,
 			&order.CreatedAt,
@@ -115,3 +155,52 @@ func (m OrderModel) GetAll() ([]Order, error) {
 
 	return orders, nil
 }
+
+// GetAllForUser returns the orders placed from the user's carts.
+func (m OrderModel) GetAllForUser(userID int64) ([]*Order, error) {
+	query := `
+			SELECT orders.id, orders.cart_id, carts.product_id, orders.variant_id, orders.order_status, orders.quantity, orders.total_price,
+			       orders.created_at, orders.updated_at, orders.deleted_at
+			FROM orders
+			INNER JOIN carts ON carts.id = orders.cart_id
+			WHERE carts.user_id = $1
+			ORDER BY orders.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*Order{}
+
+	for rows.Next() {
+		var order Order
+
+		err := rows.Scan(
+			&order.ID,
+			&order.Cart.ID,
+			&order.Cart.Product.ID,
+			&order.VariantID,
+			&order.OrderStatus,
+			&order.Quantity,
+			&order.TotalPrice,
+			&order.CreatedAt,
+			&order.UpdatedAt,
+			&order.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, &order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+const (
+	PermissionProductsWrite    = "products:write"
+	PermissionProductsManage   = "products:manage"
+	PermissionCommentsWrite    = "comments:write"
+	PermissionCartsWrite       = "carts:write"
+	PermissionOrdersWrite      = "orders:write"
+	PermissionOrdersApprove    = "orders:approve"
+	PermissionUsersManage      = "users:manage"
+	PermissionAuditRead        = "audit:read"
+	PermissionCategoriesManage = "categories:manage"
+)
+
+// PermissionCodes lists every permission there is.
+var PermissionCodes = []string{
+	PermissionProductsWrite, PermissionProductsManage, PermissionCommentsWrite, PermissionCartsWrite,
+	PermissionOrdersWrite, PermissionOrdersApprove, PermissionUsersManage, PermissionAuditRead,
+	PermissionCategoriesManage,
+}
+
+// RolePermissions holds the permissions a new user of each role is granted at
+// registration.
+var RolePermissions = map[string]Permissions{
+	"Admin": {
+		PermissionProductsWrite, PermissionProductsManage, PermissionCommentsWrite, PermissionCartsWrite,
+		PermissionOrdersWrite, PermissionOrdersApprove, PermissionUsersManage, PermissionAuditRead,
+		PermissionCategoriesManage,
+	},
+	"Client": {
+		PermissionProductsWrite, PermissionCommentsWrite, PermissionCartsWrite, PermissionOrdersWrite, PermissionOrdersApprove,
+	},
+	"Customer": {
+		PermissionCommentsWrite, PermissionCartsWrite, PermissionOrdersWrite,
+	},
+}
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+			SELECT permissions.code
+			FROM permissions
+			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+			WHERE users_permissions.user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissions(ctx, m.DB, userID, codes)
+}
+
+func addPermissions(ctx context.Context, db queryer, userID int64, codes []string) error {
+	query := `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+			ON CONFLICT DO NOTHING`
+
+	_, err := db.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// ReplaceForUser revokes every permission of the user and grants codes instead.
+func (m PermissionModel) ReplaceForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replacePermissions(ctx, tx, userID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replacePermissions(ctx context.Context, tx *sql.Tx, userID int64, codes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
@@ -7,24 +7,30 @@ import (
 	"fmt"
 	"github.com/jumagaliev1/internal/validator"
 	"github.com/lib/pq"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Product struct {
-	ID          int64      `json:"id"`
-	Category    int32      `json:"category,omitempty"`
-	User        int64      `json:"user"`
-	Title       string     `json:"title"`
-	Description string     `json:"description"`
-	Price       int        `json:"price,omitempty"`
-	Rating      float32    `json:"rating,omitempty"`
-	CountRating int        `json:"-"`
-	AllRating   int        `json:"-"`
-	Stock       int        `json:"stock"`
-	Images      []string   `json:"images"`
-	CreatedAt   time.Time  `json:"-"`
-	UpdatedAt   time.Time  `json:"-"`
-	DeletedAt   *time.Time `json:"-"`
+	ID          int64                  `json:"id"`
+	Category    int32                  `json:"category,omitempty"`
+	User        int64                  `json:"user"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Price       int                    `json:"price,omitempty"`
+	Rating      float32                `json:"rating,omitempty"`
+	CountRating int                    `json:"-"`
+	AllRating   int                    `json:"-"`
+	Stock       int                    `json:"stock"`
+	Images      []string               `json:"images"`
+	Variants    []*Variant             `json:"variants,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Snippet     string                 `json:"snippet,omitempty"`
+	Version     int32                  `json:"version"`
+	CreatedAt   time.Time              `json:"-"`
+	UpdatedAt   time.Time              `json:"-"`
+	DeletedAt   *time.Time             `json:"deleted_at,omitempty"`
 }
 
 func ValidateProduct(v *validator.Validator, p *Product) {
@@ -38,19 +44,76 @@ func ValidateProduct(v *validator.Validator, p *Product) {
 	v.Check(p.Category > 0, "category", "must be a positive integer")
 }
 
+// ValidateListProducts checks the filters of a product listing. Zero values
+// leave a filter out.
+func ValidateListProducts(v *validator.Validator, input InputListProducts) {
+	v.Check(input.Category >= 0, "category", "must be a positive integer")
+	v.Check(input.MinPrice >= 0, "min_price", "must not be negative")
+	v.Check(input.MaxPrice >= 0, "max_price", "must not be negative")
+	if input.MinPrice > 0 && input.MaxPrice > 0 {
+		v.Check(input.MaxPrice >= input.MinPrice, "max_price", "must not be less than min_price")
+	}
+	v.Check(input.MinRating >= 0 && input.MinRating <= 5, "min_rating", "must be between 0 and 5")
+	v.Check(input.Seller >= 0, "seller", "must be a positive integer")
+	v.Check(input.Title != "" || input.Filters.Sort != "relevance", "sort", "must not be relevance without a title search")
+	v.Check(input.Filters.Cursor == "" || input.Filters.Sort != "relevance", "cursor", "can't be used with the relevance sort")
+	if input.CreatedAfter != nil && input.CreatedBefore != nil {
+		v.Check(input.CreatedBefore.After(*input.CreatedAfter), "created_before", "must be later than created_after")
+	}
+}
+
 type ProductModel struct {
 	DB *sql.DB
 }
 
+// Insert stores the product together with its first variant, which takes the
+// product's price, stock and images and gets the SKU P-<product id>.
 func (m ProductModel) Insert(product *Product) error {
 	query := `
-			INSERT INTO products (title, category_id, user_id, description, price, images)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			RETURNING id, created_at`
+			INSERT INTO products (title, category_id, user_id, description, price, stock, images)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, created_at, version`
+
+	args := []interface{}{product.Title, product.Category, product.User, product.Description, product.Price, product.Stock, pq.Array(product.Images)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&product.ID, &product.CreatedAt, &product.Version)
+	if err != nil {
+		return err
+	}
+
+	variant := &Variant{
+		ProductID: product.ID,
+		SKU:       fmt.Sprintf("P-%d", product.ID),
+		Options:   map[string]string{},
+		Price:     product.Price,
+		Stock:     product.Stock,
+		Images:    product.Images,
+	}
 
-	args := []interface{}{product.Title, product.Category, product.User, product.Description, product.Price, pq.Array(product.Images)}
+	query = `
+			INSERT INTO product_variants (product_id, sku, price, stock, images)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, updated_at, version`
 
-	return m.DB.QueryRow(query, args...).Scan(&product.ID, &product.CreatedAt)
+	args = []interface{}{variant.ProductID, variant.SKU, variant.Price, variant.Stock, pq.Array(variant.Images)}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.ID, &variant.CreatedAt, &variant.UpdatedAt, &variant.Version)
+	if err != nil {
+		return err
+	}
+
+	product.Variants = []*Variant{variant}
+
+	return tx.Commit()
 }
 
 func (m ProductModel) Get(id int64) (*Product, error) {
@@ -58,9 +121,9 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-			SELECT id, category_id, user_id, title, description, price, rating,all_rating, count_rating, stock, images, created_at
+			SELECT id, category_id, user_id, title, description, price, rating,all_rating, count_rating, stock, images, created_at, version
 			FROM products 
-			WHERE id = $1`
+			WHERE id = $1 AND deleted_at IS NULL`
 
 	var product Product
 
@@ -79,7 +142,8 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 		&product.CountRating,
 		&product.Stock,
 		pq.Array(&product.Images),
-		&product.CreatedAt)
+		&product.CreatedAt,
+		&product.Version)
 
 	if err != nil {
 		switch {
@@ -92,12 +156,63 @@ func (m ProductModel) Get(id int64) (*Product, error) {
 	return &product, nil
 }
 
+// Update saves the product if it still has the version it was read with, and
+// returns ErrEditConflict if someone changed it in the meantime.
 func (m ProductModel) Update(product *Product) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateProduct(ctx, m.DB, product)
+}
+
+// UpdateWithVariant updates a product and its single variant, to which the
+// price and stock of the product are passed on, in one transaction. Either
+// version being out of date gives ErrEditConflict and leaves both unchanged.
+func (m ProductModel) UpdateWithVariant(product *Product, variant *Variant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateProduct(ctx, tx, product)
+	if err != nil {
+		return err
+	}
+
+	err = updateVariant(ctx, tx, variant)
+	if err != nil {
+		return err
+	}
+
+	err = summariseVariants(ctx, tx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	// Summarising the variants made another version of the product.
+	query := `
+		SELECT price, stock, updated_at, version
+		FROM products
+		WHERE id = $1`
+
+	err = tx.QueryRowContext(ctx, query, product.ID).Scan(&product.Price, &product.Stock, &product.UpdatedAt, &product.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateProduct(ctx context.Context, db queryer, product *Product) error {
 	query := `
 		UPDATE products
-		SET title = $1, category_id = $2, user_id = $3, description = $4, price = $5, rating = $6, all_rating = $7, count_rating = $8, stock = $9, images = $10, updated_at = now()
-		WHERE id = $11 
-		RETURNING updated_at`
+		SET title = $1, category_id = $2, user_id = $3, description = $4, price = $5, rating = $6, all_rating = $7, count_rating = $8, stock = $9, images = $10, updated_at = now(), version = version + 1
+		WHERE id = $11 AND version = $12 AND deleted_at IS NULL
+		RETURNING updated_at, version`
 
 	args := []interface{}{
 		product.Title,
@@ -111,19 +226,67 @@ func (m ProductModel) Update(product *Product) error {
 		product.Stock,
 		pq.Array(product.Images),
 		product.ID,
+		product.Version,
 	}
 
-	return m.DB.QueryRow(query, args...).Scan(&product.UpdatedAt)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&product.UpdatedAt, &product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
+// AddRating counts a rating from a comment into the product's average in a
+// single statement, so concurrent comments don't lose each other's ratings.
+func (m ProductModel) AddRating(product *Product, rating int) error {
+	query := `
+		UPDATE products
+		SET all_rating = all_rating + $2,
+		    count_rating = count_rating + 1,
+		    rating = (all_rating + $2)::float / (count_rating + 1),
+		    updated_at = now(),
+		    version = version + 1
+		WHERE id = $1
+		RETURNING rating, all_rating, count_rating, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, product.ID, rating).Scan(
+		&product.Rating,
+		&product.AllRating,
+		&product.CountRating,
+		&product.UpdatedAt,
+		&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete moves a product to the trash. Its carts, orders and comments stay
+// until PurgeDeleted removes it for good.
 func (m ProductModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-		DELETE FROM products
-		WHERE id = $1`
+		UPDATE products
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL`
 
 	result, err := m.DB.Exec(query, id)
 	if err != nil {
@@ -142,19 +305,106 @@ func (m ProductModel) Delete(id int64) error {
 	return nil
 }
 
-func (m ProductModel) GetAll(title string, category int, filters Filters) ([]*Product, Metadata, error) {
+// productFilters builds the WHERE clause of a product listing, numbering its
+// parameters from 1.
+func productFilters(input InputListProducts) (string, []interface{}) {
+	conditions := []string{
+		"deleted_at IS NULL",
+		"($1 = '' OR search @@ plainto_tsquery('products_search', $1) OR title % $1)",
+		`($2 = 0 OR category_id IN (
+				WITH RECURSIVE subtree AS (
+					SELECT id FROM categories WHERE id = $2
+					UNION
+					SELECT categories.id FROM categories INNER JOIN subtree ON categories.parent_id = subtree.id
+				)
+				SELECT id FROM subtree))`,
+	}
+	args := []interface{}{input.Title, input.Category}
+
+	// Each filter is only added when set, so the planner can use the index
+	// behind it: products_price_idx, products_in_stock_idx,
+	// products_rating_idx, products_user_id_idx or products_created_at_idx.
+	if input.MinPrice > 0 {
+		args = append(args, input.MinPrice)
+		conditions = append(conditions, fmt.Sprintf("price >= $%d", len(args)))
+	}
+	if input.MaxPrice > 0 {
+		args = append(args, input.MaxPrice)
+		conditions = append(conditions, fmt.Sprintf("price <= $%d", len(args)))
+	}
+	if input.InStock {
+		conditions = append(conditions, "stock > 0")
+	}
+	if input.MinRating > 0 {
+		args = append(args, input.MinRating)
+		conditions = append(conditions, fmt.Sprintf("rating >= $%d", len(args)))
+	}
+	if input.Seller > 0 {
+		args = append(args, input.Seller)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if input.CreatedAfter != nil {
+		args = append(args, *input.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if input.CreatedBefore != nil {
+		args = append(args, *input.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	for _, filter := range input.Attributes {
+		args = append(args, filter.Code)
+		code := len(args)
+
+		var condition string
+		if operator, ok := attributeOperators[filter.Operator]; ok {
+			number, _ := strconv.ParseFloat(filter.Values[0], 64)
+			args = append(args, number)
+			condition = fmt.Sprintf("product_attributes.value_number %s $%d", operator, len(args))
+		} else {
+			args = append(args, pq.Array(filter.Values))
+			condition = fmt.Sprintf("product_attributes.value_text = ANY($%d)", len(args))
+		}
+
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM product_attributes
+				INNER JOIN attributes ON attributes.id = product_attributes.attribute_id
+				WHERE product_attributes.product_id = products.id AND attributes.code = $%d AND %s)`, code, condition))
+	}
+
+	return strings.Join(conditions, "\n\t\t\tAND "), args
+}
+
+func (m ProductModel) GetAll(input InputListProducts) ([]*Product, Metadata, error) {
+	where, args := productFilters(input)
+
+	column := input.Filters.sortColumn()
+	keyset, order, listArgs := input.Filters.keyset(column, args)
+
+	// Products found only by trigram similarity, for a misspelt query, rank
+	// after those matching the full-text query. Relevance listings are only
+	// paged by number, so their rows have no sort key for cursors.
+	relevance := column == "relevance"
+	if relevance {
+		column = "''"
+		order = "ts_rank_cd(search, plainto_tsquery('products_search', $1)) DESC, similarity(title, $1) DESC, id ASC"
+	}
+
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(),  id, category_id, user_id, title, description, price, rating, stock, images, created_at
+			SELECT count(*) OVER(),  id, category_id, user_id, title, description, price, rating, stock, images, created_at, version,
+			       CASE WHEN $1 = '' THEN '' ELSE ts_headline('products_search', description, plainto_tsquery('products_search', $1),
+			           'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') END,
+			       %s::text
 			FROM products
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (category_id = $2 or $2 = 0)
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+			WHERE %s
+			AND %s
+			ORDER BY %s
+			LIMIT $%d OFFSET $%d`, column, where, keyset, order, len(listArgs)+1, len(listArgs)+2)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, title, category, filters.limit(), filters.offset())
+	rows, err := m.DB.QueryContext(ctx, query, append(listArgs, input.Filters.limit()+1, input.Filters.offset())...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -162,9 +412,11 @@ func (m ProductModel) GetAll(title string, category int, filters Filters) ([]*Pr
 	defer rows.Close()
 	totalRecords := 0
 	products := []*Product{}
+	positions := []cursorPosition{}
 
 	for rows.Next() {
 		var product Product
+		var position cursorPosition
 		err := rows.Scan(
 			&totalRecords,
 			&product.ID,
@@ -176,17 +428,300 @@ func (m ProductModel) GetAll(title string, category int, filters Filters) ([]*Pr
 			&product.Rating,
 			&product.Stock,
 			pq.Array(&product.Images),
-			&product.CreatedAt)
+			&product.CreatedAt,
+			&product.Version,
+			&product.Snippet,
+			&position.Key)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		position.ID = product.ID
 		products = append(products, &product)
+		positions = append(positions, position)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// The total of a keyset query only counts the rows past the cursor, so
+	// cursor pages go without page numbers.
+	metadata := calculateMetadata(totalRecords, input.Filters.Page, input.Filters.PageSize)
+	if input.Filters.Cursor != "" {
+		metadata = Metadata{PageSize: input.Filters.PageSize}
+	}
+
+	n, reverse := input.Filters.cursorPage(&metadata, positions)
+	products = products[:n]
+	if reverse {
+		for i, j := 0, len(products)-1; i < j; i, j = i+1, j-1 {
+			products[i], products[j] = products[j], products[i]
+		}
+	}
+	if relevance {
+		metadata.NextCursor, metadata.PrevCursor = "", ""
+	}
+
+	if len(input.Facets) > 0 && totalRecords > 0 {
+		metadata.Facets, err = m.facets(ctx, where, args, input.Facets)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
 
 	return products, metadata, nil
 }
+
+// facets counts, for each of the attribute codes, the products matching the
+// listing's WHERE clause per attribute value.
+func (m ProductModel) facets(ctx context.Context, where string, args []interface{}, codes []string) (map[string][]FacetValue, error) {
+	query := fmt.Sprintf(`
+			SELECT attributes.code, product_attributes.value_text, count(DISTINCT product_attributes.product_id)
+			FROM product_attributes
+			INNER JOIN attributes ON attributes.id = product_attributes.attribute_id
+			WHERE attributes.code = ANY($%d)
+			AND product_attributes.product_id IN (SELECT id FROM products WHERE %s)
+			GROUP BY attributes.code, product_attributes.value_text
+			ORDER BY attributes.code, 3 DESC, product_attributes.value_text`, len(args)+1, where)
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, pq.Array(codes))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := make(map[string][]FacetValue, len(codes))
+	for _, code := range codes {
+		facets[code] = []FacetValue{}
+	}
+
+	for rows.Next() {
+		var code string
+		var value FacetValue
+
+		err := rows.Scan(&code, &value.Value, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets[code] = append(facets[code], value)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// likePrefix turns a search-as-you-type query into a case-insensitive LIKE
+// pattern matching the strings it starts.
+func likePrefix(q string) string {
+	q = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(q))
+	return q + "%"
+}
+
+// Suggest returns up to limit distinct product titles completing q, those
+// starting with it first, then titles similar to it for a misspelt q. It has a
+// tighter timeout than other queries as it runs on every keystroke.
+func (m ProductModel) Suggest(q string, limit int) ([]string, error) {
+	query := `
+			SELECT title
+			FROM products
+			WHERE deleted_at IS NULL AND (lower(title) LIKE $2 OR title % $1)
+			GROUP BY title
+			ORDER BY lower(title) LIKE $2 DESC, similarity(title, $1) DESC, title
+			LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, likePrefix(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []string{}
+
+	for rows.Next() {
+		var title string
+
+		err := rows.Scan(&title)
+		if err != nil {
+			return nil, err
+		}
+
+		titles = append(titles, title)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// GetDeleted returns a product in the trash.
+func (m ProductModel) GetDeleted(id int64) (*Product, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+			SELECT id, category_id, user_id, title, description, price, rating, stock, images, created_at, updated_at, deleted_at, version
+			FROM products
+			WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	var product Product
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&product.ID,
+		&product.Category,
+		&product.User,
+		&product.Title,
+		&product.Description,
+		&product.Price,
+		&product.Rating,
+		&product.Stock,
+		pq.Array(&product.Images),
+		&product.CreatedAt,
+		&product.UpdatedAt,
+		&product.DeletedAt,
+		&product.Version)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &product, nil
+}
+
+// GetAllDeleted lists the products in the trash, those of one seller when
+// userID isn't 0.
+func (m ProductModel) GetAllDeleted(userID int64, filters Filters) ([]*Product, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), id, category_id, user_id, title, description, price, rating, stock, images, created_at, updated_at, deleted_at, version
+			FROM products
+			WHERE deleted_at IS NOT NULL
+			AND ($1 = 0 OR user_id = $1)
+			ORDER BY %s %s, id ASC
+			LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	products := []*Product{}
+
+	for rows.Next() {
+		var product Product
+		err := rows.Scan(
+			&totalRecords,
+			&product.ID,
+			&product.Category,
+			&product.User,
+			&product.Title,
+			&product.Description,
+			&product.Price,
+			&product.Rating,
+			&product.Stock,
+			pq.Array(&product.Images),
+			&product.CreatedAt,
+			&product.UpdatedAt,
+			&product.DeletedAt,
+			&product.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		products = append(products, &product)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return products, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Restore takes a product out of the trash.
+func (m ProductModel) Restore(product *Product) error {
+	query := `
+			UPDATE products
+			SET deleted_at = NULL, updated_at = now(), version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, product.ID).Scan(&product.UpdatedAt, &product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	product.DeletedAt = nil
+	return nil
+}
+
+// PurgeDeleted removes the products that have been in the trash since before
+// the given time, along with their carts, comments and variants. Products
+// that orders refer to are kept, so order history stays intact. It returns
+// the products removed, with their IDs and images, so their stored files can
+// be deleted too.
+func (m ProductModel) PurgeDeleted(ctx context.Context, before time.Time) ([]*Product, error) {
+	query := `
+			DELETE FROM products
+			WHERE deleted_at < $1
+			AND NOT EXISTS (
+				SELECT 1 FROM orders
+				INNER JOIN carts ON carts.id = orders.cart_id
+				WHERE carts.product_id = products.id
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM orders
+				INNER JOIN product_variants ON product_variants.id = orders.variant_id
+				WHERE product_variants.product_id = products.id
+			)
+			RETURNING id, images`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []*Product{}
+
+	for rows.Next() {
+		var product Product
+
+		err := rows.Scan(&product.ID, pq.Array(&product.Images))
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, &product)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Revocation records a stateless token that must no longer be accepted. With a
+// JTI it revokes that single token, without one it revokes every token of the
+// user issued up to RevokedAt.
+type Revocation struct {
+	JTI       string
+	UserID    int64
+	RevokedAt time.Time
+	Expiry    time.Time
+}
+
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+func (m RevocationModel) Insert(revocation *Revocation) error {
+	query := `
+			INSERT INTO token_revocations (jti, user_id, expiry)
+			VALUES (NULLIF($1, ''), $2, $3)
+			ON CONFLICT (jti) DO NOTHING
+			RETURNING revoked_at`
+
+	args := []interface{}{revocation.JTI, revocation.UserID, revocation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&revocation.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The token had been revoked already.
+		return nil
+	}
+	return err
+}
+
+// GetAllActive returns the revocations of tokens which haven't expired yet.
+func (m RevocationModel) GetAllActive() ([]*Revocation, error) {
+	query := `
+			SELECT COALESCE(jti, ''), user_id, revoked_at, expiry
+			FROM token_revocations
+			WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := []*Revocation{}
+
+	for rows.Next() {
+		var revocation Revocation
+		err := rows.Scan(
+			&revocation.JTI,
+			&revocation.UserID,
+			&revocation.RevokedAt,
+			&revocation.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, &revocation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+func (m RevocationModel) DeleteExpired() error {
+	query := `
+			DELETE FROM token_revocations
+			WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"github.com/jumagaliev1/internal/validator"
 	"time"
 )
@@ -13,14 +14,34 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeMFA            = "mfa"
+	ScopeEmailChange    = "email-change"
 )
 
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext string     `json:"token"`
+	Hash      []byte     `json:"-"`
+	UserID    int64      `json:"-"`
+	Expiry    time.Time  `json:"expiry"`
+	Scope     string     `json:"-"`
+	Family    []byte     `json:"-"`
+	RotatedAt *time.Time `json:"-"`
+	UserAgent string     `json:"-"`
+	IP        string     `json:"-"`
+}
+
+// Session describes a live token of a user without exposing the token itself.
+type Session struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -61,14 +82,115 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewFamily returns a random identifier for a new token family.
+func NewFamily() ([]byte, error) {
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
+// NewForFamily creates a token like New, additionally recording the family it
+// belongs to and the client it was issued to.
+func (m TokenModel) NewForFamily(userID int64, ttl time.Duration, scope string, family []byte, userAgent, ip string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+	token.UserAgent = userAgent
+	token.IP = ip
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewPair issues a short-lived authentication token together with a long-lived
+// refresh token. Both belong to the same family, which identifies the login
+// session across rotations. A nil family starts a new session. With a zero
+// accessTTL only the refresh token is issued, for clients whose authentication
+// token isn't stored.
+func (m TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration, family []byte, userAgent, ip string) (*Token, *Token, error) {
+	var err error
+	if family == nil {
+		family, err = NewFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertPair(ctx, tx, userID, accessTTL, refreshTTL, family, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+func insertPair(ctx context.Context, tx *sql.Tx, userID int64, accessTTL, refreshTTL time.Duration, family []byte, userAgent, ip string) (*Token, *Token, error) {
+	var access *Token
+
+	if accessTTL > 0 {
+		token, err := generateToken(userID, accessTTL, ScopeAuthentication)
+		if err != nil {
+			return nil, nil, err
+		}
+		token.Family, token.UserAgent, token.IP = family, userAgent, ip
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+		access = token
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh.Family, refresh.UserAgent, refresh.IP = family, userAgent, ip
+
+	err = insertToken(ctx, tx, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
 func (m TokenModel) Insert(token *Token) error {
-	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	return insertToken(ctx, m.DB, token)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertToken(ctx context.Context, db queryer, token *Token) error {
+	query := `
+INSERT INTO tokens (hash, user_id, expiry, scope, family, user_agent, ip)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family, token.UserAgent, token.IP}
+	_, err := db.ExecContext(ctx, query, args...)
 	return err
 }
 
@@ -81,3 +203,178 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// Get looks up an unexpired token, including refresh tokens which have already
+// been rotated.
+func (m TokenModel) Get(scope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		SELECT hash, user_id, expiry, scope, family, rotated_at, user_agent, ip
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	token := Token{Plaintext: tokenPlaintext}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.Family,
+		&token.RotatedAt,
+		&token.UserAgent,
+		&token.IP)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &token, nil
+}
+
+// Rotate marks a refresh token as used, revokes the authentication tokens
+// issued alongside it and issues the next pair of the family like NewPair, all
+// in one transaction. ErrTokenReused is returned if the token had already been
+// rotated, which includes losing a race against a concurrent rotation.
+func (m TokenModel) Rotate(token *Token, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	query := `
+		UPDATE tokens
+		SET rotated_at = now(), last_used_at = now()
+		WHERE hash = $1 AND scope = $2 AND rotated_at IS NULL
+		RETURNING family`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var family []byte
+	err = tx.QueryRowContext(ctx, query, token.Hash, ScopeRefresh).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrTokenReused
+		default:
+			return nil, nil, err
+		}
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE family = $1 AND scope = $2`
+
+	_, err = tx.ExecContext(ctx, query, family, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertPair(ctx, tx, token.UserID, accessTTL, refreshTTL, family, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+func (m TokenModel) DeleteFamily(family []byte) error {
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, family)
+	return err
+}
+
+// DeleteForToken revokes a token along with every other token of its family, so
+// that logging out also invalidates the matching refresh token.
+func (m TokenModel) DeleteForToken(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		DELETE FROM tokens
+		WHERE (scope = $1 AND hash = $2)
+		OR family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return err
+}
+
+// Touch records that the token, and so its session, has just been used. To keep
+// this cheap on every request the rows are only written once a minute.
+func (m TokenModel) Touch(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		UPDATE tokens
+		SET last_used_at = now()
+		WHERE ((scope = $1 AND hash = $2)
+			OR (scope = $3 AND rotated_at IS NULL AND family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)))
+		AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:], ScopeRefresh)
+	return err
+}
+
+// GetAllForUser lists the live sessions of a user. A session is either a token
+// family, represented by its current refresh token, or a standalone
+// authentication token. The session of the current request, known either by
+// its opaque token or by its family, is flagged as current.
+func (m TokenModel) GetAllForUser(userID int64, currentTokenPlaintext string, currentFamily []byte) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentTokenPlaintext))
+	query := `
+		SELECT COALESCE((SELECT min(f.created_at) FROM tokens f WHERE f.family = t.family), t.created_at) AS started_at,
+			t.last_used_at, t.expiry, t.user_agent, t.ip,
+			COALESCE(t.hash = $2 OR t.family = COALESCE($6, (SELECT c.family FROM tokens c WHERE c.hash = $2)), false)
+		FROM tokens t
+		WHERE t.user_id = $1 AND t.expiry > $3 AND t.rotated_at IS NULL
+		AND (t.scope = $4 OR (t.scope = $5 AND t.family IS NULL))
+		ORDER BY started_at DESC`
+	args := []interface{}{userID, currentHash[:], time.Now(), ScopeRefresh, ScopeAuthentication, currentFamily}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
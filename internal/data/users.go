@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jumagaliev1/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 	"time"
@@ -25,18 +26,27 @@ var (
 )
 var AnonymousUser = &User{}
 
+// dummyPasswordHash is a bcrypt hash of a random password with the same cost as
+// real ones. Comparing against it when an account doesn't exist makes a failed
+// login take as long as one with a wrong password.
+var dummyPasswordHash = []byte("$2a$12$LTWso3e1q20zBHOX4tWux.sCIaco1bTqzOFjf/SWmMZRZ3FhoFs5q")
+
 type User struct {
-	ID        int64      `json:"id"`
-	FirstName string     `json:"first_name"`
-	LastName  string     `json:"last_name"`
-	Email     string     `json:"email"`
-	Phone     *string    `json:"phone"`
-	Address   *string    `json:"address"`
-	Password  password   `json:"-"`
-	Role      string     `json:"role"`
-	CreatedAt time.Time  `json:"-"`
-	UpdatedAt time.Time  `json:"-"`
-	DeletedAt *time.Time `json:"-"`
+	ID           int64      `json:"id"`
+	FirstName    string     `json:"first_name"`
+	LastName     string     `json:"last_name"`
+	Email        string     `json:"email"`
+	Phone        *string    `json:"phone"`
+	Address      *string    `json:"address"`
+	Password     password   `json:"-"`
+	Role         string     `json:"role"`
+	Activated    bool       `json:"activated"`
+	Suspended    bool       `json:"suspended"`
+	PendingEmail *string    `json:"pending_email,omitempty"`
+	Version      int32      `json:"version"`
+	CreatedAt    time.Time  `json:"-"`
+	UpdatedAt    time.Time  `json:"-"`
+	DeletedAt    *time.Time `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -68,7 +78,17 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// IsSet reports whether the user has a password at all. Users who signed up
+// through an external identity provider don't.
+func (p *password) IsSet() bool {
+	return len(p.hash) > 0
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if !p.IsSet() {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -81,6 +101,13 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// MatchesNothing spends the time of a real password check and always fails.
+// Use it when there is no user to check the password against.
+func MatchesNothing(plaintextPassword string) bool {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+	return false
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -101,12 +128,15 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	ValidateEmail(v, user.Email)
 
-	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+	if user.Phone != nil {
+		v.Check(len(*user.Phone) <= 20, "phone", "must not be more than 20 bytes long")
+	}
+	if user.Address != nil {
+		v.Check(len(*user.Address) <= 500, "address", "must not be more than 500 bytes long")
 	}
 
-	if user.Password.hash == nil {
-		panic("missing password hash for user")
+	if user.Password.plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
 }
 
@@ -115,16 +145,76 @@ type UserModel struct {
 }
 
 func (m UserModel) Insert(user *User) error {
-	query := `
-			INSERT INTO users (first_name, last_name, email, phone, password_hash, role)
-			VALUES ($1, $2, $3, $4, $5, $6)
-			RETURNING id, created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
 
-	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Phone, user.Password.hash, Roles_value[user.Role]}
+// Register creates an account in one transaction together with the
+// permissions of its role and, unless it is activated already, an activation
+// token valid for activationTTL, which is returned. A non-nil identity is
+// linked to the account as well. Without the transaction a failure half way
+// would leave an account behind whose email can't be registered again.
+func (m UserModel) Register(user *User, permissions []string, activationTTL time.Duration, identity *Identity) (*Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addPermissions(ctx, tx, user.ID, permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity != nil {
+		identity.UserID = user.ID
+
+		err = insertIdentity(ctx, tx, identity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var token *Token
+	if !user.Activated {
+		token, err = generateToken(user.ID, activationTTL, ScopeActivation)
+		if err != nil {
+			return nil, err
+		}
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func insertUser(ctx context.Context, db queryer, user *User) error {
+	query := `
+			INSERT INTO users (first_name, last_name, email, phone, password_hash, role, activated)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, created_at`
+
+	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Phone, user.Password.hash, Roles_value[user.Role], user.Activated}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -138,11 +228,12 @@ func (m UserModel) Insert(user *User) error {
 }
 func (m UserModel) GetByID(id int) (*User, error) {
 	query := `
-			SELECT id, first_name, last_name, email, phone, password_hash, role, created_at
+			SELECT id, first_name, last_name, email, phone, address, password_hash, role, activated, suspended, pending_email, version, created_at
 			FROM users
-			WHERE id = $1`
+			WHERE id = $1 AND deleted_at IS NULL`
 
 	var user User
+	var role int32
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -153,9 +244,13 @@ func (m UserModel) GetByID(id int) (*User, error) {
 		&user.LastName,
 		&user.Email,
 		&user.Phone,
-		//&user.Address,
+		&user.Address,
 		&user.Password.hash,
-		&user.Role,
+		&role,
+		&user.Activated,
+		&user.Suspended,
+		&user.PendingEmail,
+		&user.Version,
 		&user.CreatedAt,
 	)
 
@@ -167,15 +262,17 @@ func (m UserModel) GetByID(id int) (*User, error) {
 			return nil, err
 		}
 	}
+	user.Role = Roles_name[role]
 	return &user, nil
 }
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-			SELECT id, first_name, last_name, email, phone, password_hash, role, created_at
+			SELECT id, first_name, last_name, email, phone, address, password_hash, role, activated, suspended, pending_email, version, created_at
 			FROM users
-			WHERE email = $1`
+			WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
+	var role int32
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -186,9 +283,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Email,
 		&user.Phone,
-		//&user.Address,
+		&user.Address,
 		&user.Password.hash,
-		&user.Role,
+		&role,
+		&user.Activated,
+		&user.Suspended,
+		&user.PendingEmail,
+		&user.Version,
 		&user.CreatedAt,
 	)
 
@@ -200,15 +301,52 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 			return nil, err
 		}
 	}
+	user.Role = Roles_name[role]
 	return &user, nil
 }
 
+// Update saves every editable field of user, provided nobody else has changed
+// the record since it was read. Otherwise it returns ErrEditConflict.
 func (m UserModel) Update(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateUser(ctx, m.DB, user)
+}
+
+// UpdateWithPermissions updates a user and replaces their permissions in one
+// transaction, so that a new role is never saved without the permissions
+// that go with it.
+func (m UserModel) UpdateWithPermissions(user *User, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = replacePermissions(ctx, tx, user.ID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateUser(ctx context.Context, db queryer, user *User) error {
 	query := `
 			UPDATE users
-			SET first_name = $1, last_name = $2, email = $3, phone = $4, address = $5, password_hash = $6, role = $7, updated_at = now(),
-			WHERE id = 8
-			RETURNING updated_at`
+			SET first_name = $1, last_name = $2, email = $3, phone = $4, address = $5, password_hash = $6, role = $7,
+			    suspended = $8, pending_email = $9, updated_at = now(), version = version + 1
+			WHERE id = $10 AND version = $11 AND deleted_at IS NULL
+			RETURNING updated_at, version`
 	args := []interface{}{
 		user.FirstName,
 		user.LastName,
@@ -216,12 +354,13 @@ func (m UserModel) Update(user *User) error {
 		user.Phone,
 		user.Address,
 		user.Password.hash,
-		user.Role,
+		Roles_value[user.Role],
+		user.Suspended,
+		user.PendingEmail,
 		user.ID,
+		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt, &user.Version)
 
 	if err != nil {
 		switch {
@@ -236,30 +375,109 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-//func (m UserModel) Delete(id int64) error {
-//	query := `DELETE from users where id = $1`
-//
-//	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-//	defer cancel()
-//
-//	err := m.DB.ExecContext(ctx, query, id)
-//	return nil
-//}
+func (m UserModel) Activate(user *User) error {
+	query := `
+			UPDATE users
+			SET activated = true, updated_at = now(), version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING activated, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Activated, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m UserModel) UpdatePassword(user *User) error {
+	query := `
+			UPDATE users
+			SET password_hash = $1, updated_at = now(), version = version + 1
+			WHERE id = $2 AND deleted_at IS NULL
+			RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.UpdatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete soft-deletes the account and anonymises the personal data on it. The
+// row itself stays, so orders and comments keep pointing at it but no longer
+// identify anybody. Tokens, second factors and permissions are removed.
+func (m UserModel) Delete(user *User) error {
+	query := `
+			UPDATE users
+			SET first_name = 'Deleted', last_name = 'User', email = 'deleted-' || id || '@users.invalid',
+			    phone = NULL, address = NULL, password_hash = '', pending_email = NULL, activated = false,
+			    deleted_at = now(), updated_at = now(), version = version + 1
+			WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, user.ID, user.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	for _, table := range []string{"tokens", "users_totp", "recovery_codes", "users_permissions", "user_identities", "api_keys"} {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
 
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	query := `
-			SELECT users.id, users.created_at, users.first_name, users.last_name, users.email, users.password_hash, users.role
+			SELECT users.id, users.created_at, users.first_name, users.last_name, users.email, users.phone, users.address, users.password_hash, users.role, users.activated, users.suspended, users.pending_email, users.version
 			FROM users
 			INNER JOIN tokens
 			ON users.id = tokens.user_id
 			WHERE tokens.hash = $1
 			AND tokens.scope = $2
-			AND tokens.expiry > $3`
+			AND tokens.expiry > $3
+			AND users.deleted_at IS NULL`
 
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
+	var role int32
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -270,8 +488,14 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.FirstName,
 		&user.LastName,
 		&user.Email,
+		&user.Phone,
+		&user.Address,
 		&user.Password.hash,
-		&user.Role,
+		&role,
+		&user.Activated,
+		&user.Suspended,
+		&user.PendingEmail,
+		&user.Version,
 	)
 	if err != nil {
 		switch {
@@ -281,5 +505,70 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 			return nil, err
 		}
 	}
+	user.Role = Roles_name[role]
 	return &user, nil
 }
+
+func (m UserModel) GetAll(input InputListUsers) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+			SELECT count(*) OVER(), id, first_name, last_name, email, phone, address, role, activated, suspended, version, created_at
+			FROM users
+			WHERE deleted_at IS NULL
+			AND (role = $1 OR $1 = -1)
+			AND (strpos(lower(email::text), lower($2)) > 0 OR $2 = '')
+			AND (created_at >= $3::timestamptz OR $3::timestamptz IS NULL)
+			AND (created_at < $4::timestamptz OR $4::timestamptz IS NULL)
+			ORDER BY %s %s, id ASC
+			LIMIT $5 OFFSET $6`, input.Filters.sortColumn(), input.Filters.sortDirection())
+
+	role := int32(-1)
+	if input.Role != "" {
+		role = Roles_value[input.Role]
+	}
+
+	args := []interface{}{role, input.Email, input.CreatedFrom, input.CreatedTo, input.Filters.limit(), input.Filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		var role int32
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.Phone,
+			&user.Address,
+			&role,
+			&user.Activated,
+			&user.Suspended,
+			&user.Version,
+			&user.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		user.Role = Roles_name[role]
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, input.Filters.Page, input.Filters.PageSize)
+
+	return users, metadata, nil
+}
@@ -0,0 +1,336 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jumagaliev1/internal/validator"
+	"github.com/lib/pq"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrDuplicateSKU   = errors.New("duplicate sku")
+	ErrLastVariant    = errors.New("last variant")
+	ErrVariantOrdered = errors.New("variant ordered")
+)
+
+var SKURX = regexp.MustCompile("^[A-Za-z0-9][A-Za-z0-9._-]*$")
+
+// Variant is a purchasable version of a product, like a shirt in one size and
+// colour. Every product has at least one. The product's own price and stock
+// summarise its variants: the lowest price and the total stock.
+type Variant struct {
+	ID        int64             `json:"id"`
+	ProductID int64             `json:"product_id"`
+	SKU       string            `json:"sku"`
+	Options   map[string]string `json:"options"`
+	Price     int               `json:"price"`
+	Stock     int               `json:"stock"`
+	Images    []string          `json:"images"`
+	Version   int32             `json:"version"`
+	CreatedAt time.Time         `json:"-"`
+	UpdatedAt time.Time         `json:"-"`
+}
+
+func ValidateVariant(v *validator.Validator, variant *Variant) {
+	v.Check(variant.SKU != "", "sku", "must be provided")
+	v.Check(len(variant.SKU) <= 64, "sku", "must not be more than 64 bytes long")
+	v.Check(validator.Matches(variant.SKU, SKURX), "sku", "must only contain letters, digits, dots, hyphens and underscores")
+
+	v.Check(len(variant.Options) <= 10, "options", "must not contain more than 10 options")
+	for name, value := range variant.Options {
+		v.Check(name != "" && len(name) <= 50, "options", "must have names of 1 to 50 bytes")
+		v.Check(value != "" && len(value) <= 100, "options", "must have values of 1 to 100 bytes")
+	}
+
+	v.Check(variant.Price >= 100, "price", "must be greater than 100")
+	v.Check(variant.Stock >= 0, "stock", "must not be negative")
+
+	v.Check(len(variant.Images) <= 20, "images", "must not contain more than 20 images")
+}
+
+type VariantModel struct {
+	DB *sql.DB
+}
+
+func (m VariantModel) Insert(variant *Variant) error {
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			INSERT INTO product_variants (product_id, sku, options, price, stock, images)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{variant.ProductID, variant.SKU, options, variant.Price, variant.Stock, pq.Array(variant.Images)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.ID, &variant.CreatedAt, &variant.UpdatedAt, &variant.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "product_variants_sku_idx"`:
+			return ErrDuplicateSKU
+		default:
+			return err
+		}
+	}
+
+	err = summariseVariants(ctx, tx, variant.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m VariantModel) Get(id int64) (*Variant, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, product_id, sku, options, price, stock, images, version, created_at, updated_at
+			FROM product_variants
+			WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	variant, err := scanVariant(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return variant, nil
+}
+
+func (m VariantModel) GetAllForProduct(productID int64) ([]*Variant, error) {
+	query := `
+			SELECT id, product_id, sku, options, price, stock, images, version, created_at, updated_at
+			FROM product_variants
+			WHERE product_id = $1
+			ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []*Variant{}
+
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, variant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
+
+func (m VariantModel) Update(variant *Variant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateVariant(ctx, tx, variant)
+	if err != nil {
+		return err
+	}
+
+	err = summariseVariants(ctx, tx, variant.ProductID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateVariant(ctx context.Context, tx *sql.Tx, variant *Variant) error {
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return err
+	}
+
+	query := `
+			UPDATE product_variants
+			SET sku = $1, options = $2, price = $3, stock = $4, images = $5, updated_at = now(), version = version + 1
+			WHERE id = $6 AND product_id = $7 AND version = $8
+			RETURNING updated_at, version`
+
+	args := []interface{}{
+		variant.SKU,
+		options,
+		variant.Price,
+		variant.Stock,
+		pq.Array(variant.Images),
+		variant.ID,
+		variant.ProductID,
+		variant.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&variant.UpdatedAt, &variant.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "product_variants_sku_idx"`:
+			return ErrDuplicateSKU
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a variant of the given product. The last variant of a product
+// can't be deleted and gives ErrLastVariant. Neither can variants which have
+// been ordered, as the orders keep pointing at them, which gives
+// ErrVariantOrdered.
+func (m VariantModel) Delete(id, productID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the product serialises deletes, so two of them can't remove
+	// the last two variants at once.
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT (SELECT count(*) FROM product_variants WHERE product_id = $1) FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&count)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM orders
+			INNER JOIN product_variants ON product_variants.id = orders.variant_id
+			WHERE orders.variant_id = $1 AND product_variants.product_id = $2)`
+
+	var ordered bool
+	err = tx.QueryRowContext(ctx, query, id, productID).Scan(&ordered)
+	if err != nil {
+		return err
+	}
+	if ordered {
+		return ErrVariantOrdered
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM product_variants WHERE id = $1 AND product_id = $2`, id, productID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrVariantOrdered
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	if count <= 1 {
+		return ErrLastVariant
+	}
+
+	err = summariseVariants(ctx, tx, productID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// summariseVariants sets the price of a product to the lowest price of its
+// variants and its stock to their total stock. That makes a new version of the
+// product, so edits based on the old price and stock conflict.
+func summariseVariants(ctx context.Context, tx *sql.Tx, productID int64) error {
+	query := `
+			UPDATE products
+			SET price = coalesce((SELECT min(price) FROM product_variants WHERE product_id = $1), price),
+			    stock = coalesce((SELECT sum(stock) FROM product_variants WHERE product_id = $1), 0),
+			    updated_at = now(),
+			    version = version + 1
+			WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, productID)
+	return err
+}
+
+type variantScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVariant(row variantScanner) (*Variant, error) {
+	var variant Variant
+	var options []byte
+
+	err := row.Scan(
+		&variant.ID,
+		&variant.ProductID,
+		&variant.SKU,
+		&options,
+		&variant.Price,
+		&variant.Stock,
+		pq.Array(&variant.Images),
+		&variant.Version,
+		&variant.CreatedAt,
+		&variant.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(options, &variant.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &variant, nil
+}
@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist holds revoked tokens until they would have expired anyway. A token
+// is revoked either by its jti, or together with every other token issued to
+// the same subject up to a point in time.
+type Denylist struct {
+	mu       sync.RWMutex
+	ids      map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+type subjectRevocation struct {
+	before time.Time
+	expiry time.Time
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{
+		ids:      make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+// Revoke denies the token with the given jti. expiry is the token's own expiry,
+// after which the entry is no longer needed.
+func (d *Denylist) Revoke(jti string, expiry time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ids[jti] = expiry
+}
+
+// RevokeSubject denies every token of subject issued before the given time. expiry is when the last of those tokens expires.
+func (d *Denylist) RevokeSubject(subject string, before, expiry time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, ok := d.subjects[subject]
+	if !ok || before.After(current.before) {
+		current.before = before
+	}
+	if expiry.After(current.expiry) {
+		current.expiry = expiry
+	}
+	d.subjects[subject] = current
+}
+
+func (d *Denylist) IsRevoked(c RegisteredClaims) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, ok := d.ids[c.ID]; ok {
+		return true
+	}
+
+	revocation, ok := d.subjects[c.Subject]
+	return ok && c.IssuedAt < float64(revocation.before.UnixMicro())/1e6
+}
+
+// Prune drops the entries which have expired by now.
+func (d *Denylist) Prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for jti, expiry := range d.ids {
+		if !expiry.After(now) {
+			delete(d.ids, jti)
+		}
+	}
+
+	for subject, revocation := range d.subjects {
+		if !revocation.expiry.After(now) {
+			delete(d.subjects, subject)
+		}
+	}
+}
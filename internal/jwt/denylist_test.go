@@ -0,0 +1,83 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDenylist(t *testing.T) {
+	revokedAt := time.Date(2024, 1, 2, 3, 4, 5, 500*int(time.Millisecond), time.UTC)
+	expiry := revokedAt.Add(time.Hour)
+
+	d := NewDenylist()
+	d.Revoke("stolen", expiry)
+	d.RevokeSubject("42", revokedAt, expiry)
+
+	tests := []struct {
+		name   string
+		claims RegisteredClaims
+		want   bool
+	}{
+		{name: "revoked jti", claims: RegisteredClaims{ID: "stolen", Subject: "7", IssuedAt: NumericDate(revokedAt.Add(time.Minute))}, want: true},
+		{name: "other jti", claims: RegisteredClaims{ID: "other", Subject: "7", IssuedAt: NumericDate(revokedAt.Add(-time.Minute))}},
+		{name: "issued before the revocation", claims: RegisteredClaims{Subject: "42", IssuedAt: NumericDate(revokedAt.Add(-time.Minute))}, want: true},
+		{name: "issued earlier in the same second", claims: RegisteredClaims{Subject: "42", IssuedAt: NumericDate(revokedAt.Add(-100 * time.Millisecond))}, want: true},
+		{name: "issued later in the same second", claims: RegisteredClaims{Subject: "42", IssuedAt: NumericDate(revokedAt.Add(100 * time.Millisecond))}},
+		{name: "issued at the revocation", claims: RegisteredClaims{Subject: "42", IssuedAt: NumericDate(revokedAt)}},
+		{name: "issued after the revocation", claims: RegisteredClaims{Subject: "42", IssuedAt: NumericDate(revokedAt.Add(time.Minute))}},
+		{name: "other subject", claims: RegisteredClaims{Subject: "7", IssuedAt: NumericDate(revokedAt.Add(-time.Minute))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.IsRevoked(tt.claims); got != tt.want {
+				t.Errorf("IsRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenylistRevokeSubjectKeepsTheLatestCutoff(t *testing.T) {
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.Add(time.Minute)
+
+	d := NewDenylist()
+	d.RevokeSubject("42", second, second.Add(time.Hour))
+	// Revocations synced from the database may arrive out of order.
+	d.RevokeSubject("42", first, first.Add(time.Hour))
+
+	claims := RegisteredClaims{Subject: "42", IssuedAt: NumericDate(first.Add(time.Second))}
+	if !d.IsRevoked(claims) {
+		t.Error("token issued between both revocations is not revoked")
+	}
+}
+
+func TestDenylistPrune(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	d := NewDenylist()
+	d.Revoke("expired", now.Add(-time.Second))
+	d.Revoke("live", now.Add(time.Minute))
+	d.RevokeSubject("1", now.Add(-time.Hour), now)
+	d.RevokeSubject("2", now.Add(-time.Hour), now.Add(time.Minute))
+
+	d.Prune(now)
+
+	issued := NumericDate(now.Add(-2 * time.Hour))
+
+	tests := []struct {
+		claims RegisteredClaims
+		want   bool
+	}{
+		{claims: RegisteredClaims{ID: "expired"}},
+		{claims: RegisteredClaims{ID: "live"}, want: true},
+		{claims: RegisteredClaims{Subject: "1", IssuedAt: issued}},
+		{claims: RegisteredClaims{Subject: "2", IssuedAt: issued}, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := d.IsRevoked(tt.claims); got != tt.want {
+			t.Errorf("IsRevoked(%+v) after Prune = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("jwt: invalid token")
+	ErrExpiredToken = errors.New("jwt: token has expired")
+	ErrUnknownKey   = errors.New("jwt: unknown signing key")
+)
+
+// leeway tolerates small clock differences between servers when checking the
+// time based claims.
+const leeway = 30 * time.Second
+
+var encoding = base64.RawURLEncoding
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// RegisteredClaims holds the standard claims that Verify checks. Embed it in
+// a struct to carry application specific claims. IssuedAt may have a fraction
+// of a second, so it can be told apart from a revocation in the same second.
+type RegisteredClaims struct {
+	ID        string  `json:"jti,omitempty"`
+	Issuer    string  `json:"iss,omitempty"`
+	Subject   string  `json:"sub,omitempty"`
+	IssuedAt  float64 `json:"iat,omitempty"`
+	ExpiresAt int64   `json:"exp,omitempty"`
+	NotBefore int64   `json:"nbf,omitempty"`
+}
+
+// NumericDate turns a time into seconds since the epoch with millisecond
+// precision, for the IssuedAt claim.
+func NumericDate(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+func (c RegisteredClaims) Registered() RegisteredClaims {
+	return c
+}
+
+type Claims interface {
+	Registered() RegisteredClaims
+}
+
+// Key is a named key for one algorithm. HS256 keys use a shared secret, EdDSA
+// keys an Ed25519 key pair; an EdDSA key without a private half can only
+// verify.
+type Key struct {
+	ID         string
+	Algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func NewHS256Key(kid string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt: key %q: HS256 secret must be at least 32 bytes long", kid)
+	}
+	return &Key{ID: kid, Algorithm: AlgHS256, secret: secret}, nil
+}
+
+// NewEd25519Key accepts either a 32 byte seed or a 64 byte private key.
+func NewEd25519Key(kid string, private []byte) (*Key, error) {
+	var pk ed25519.PrivateKey
+	switch len(private) {
+	case ed25519.SeedSize:
+		pk = ed25519.NewKeyFromSeed(private)
+	case ed25519.PrivateKeySize:
+		pk = ed25519.PrivateKey(private)
+	default:
+		return nil, fmt.Errorf("jwt: key %q: invalid Ed25519 private key length", kid)
+	}
+	return &Key{ID: kid, Algorithm: AlgEdDSA, privateKey: pk, publicKey: pk.Public().(ed25519.PublicKey)}, nil
+}
+
+func NewEd25519PublicKey(kid string, public []byte) (*Key, error) {
+	if len(public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("jwt: key %q: invalid Ed25519 public key length", kid)
+	}
+	return &Key{ID: kid, Algorithm: AlgEdDSA, publicKey: ed25519.PublicKey(public)}, nil
+}
+
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.privateKey != nil
+}
+
+func (k *Key) sign(signingInput []byte) []byte {
+	switch k.Algorithm {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	default:
+		return ed25519.Sign(k.privateKey, signingInput)
+	}
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgHS256:
+		return hmac.Equal(k.sign(signingInput), signature)
+	default:
+		return ed25519.Verify(k.publicKey, signingInput, signature)
+	}
+}
+
+// ParseKeys reads a comma separated list of keys in the form
+// "kid:alg:base64key", for example "2023-01:HS256:c2VjcmV0...". Ed25519 keys
+// can be given as a private seed ("EdDSA") or, for verification only, as a
+// public key ("EdDSA-public").
+func ParseKeys(spec string) ([]*Key, error) {
+	var keys []*Key
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("jwt: invalid key %q, expected kid:alg:base64key", item)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", parts[0], err)
+		}
+
+		var key *Key
+		switch parts[1] {
+		case AlgHS256:
+			key, err = NewHS256Key(parts[0], material)
+		case AlgEdDSA:
+			key, err = NewEd25519Key(parts[0], material)
+		case AlgEdDSA + "-public":
+			key, err = NewEd25519PublicKey(parts[0], material)
+		default:
+			err = fmt.Errorf("jwt: key %q: unsupported algorithm %q", parts[0], parts[1])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// KeySet signs tokens with one key and verifies them with any key it knows by
+// its kid, which lets keys be rotated without logging everybody out.
+type KeySet struct {
+	keys    map[string]*Key
+	signing *Key
+}
+
+func NewKeySet(signingKID string, keys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key)}
+
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	signing, ok := ks.keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: signing key %q is not in the key set", signingKID)
+	}
+	if !signing.canSign() {
+		return nil, fmt.Errorf("jwt: signing key %q has no private key", signingKID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: ks.signing.Algorithm, Type: "JWT", KeyID: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+	signature := ks.signing.sign([]byte(signingInput))
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature and the time based claims of token and decodes
+// its payload into claims.
+func (ks *KeySet) Verify(token string, claims Claims) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrInvalidToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	var h header
+	err = json.Unmarshal(rawHeader, &h)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	// The algorithm is dictated by the key, never by the token, so a token
+	// can't downgrade itself to a weaker or different algorithm.
+	if h.Algorithm != key.Algorithm {
+		return ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	return validateTime(claims.Registered(), time.Now())
+}
+
+func validateTime(c RegisteredClaims, now time.Time) error {
+	if c.ExpiresAt == 0 || now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrExpiredToken
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Unix() < c.NotBefore {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// LooksLikeJWT reports whether token has the three dot separated segments of a
+// compact JWT, as opposed to an opaque token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
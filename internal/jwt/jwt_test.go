@@ -0,0 +1,280 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClaims struct {
+	RegisteredClaims
+	Role string `json:"role"`
+}
+
+func mustHS256Key(t *testing.T, kid string) *Key {
+	t.Helper()
+
+	key, err := NewHS256Key(kid, bytes.Repeat([]byte(kid[:1]), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustEd25519Key(t *testing.T, kid string) *Key {
+	t.Helper()
+
+	key, err := NewEd25519Key(kid, bytes.Repeat([]byte(kid[:1]), ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustKeySet(t *testing.T, signingKID string, keys ...*Key) *KeySet {
+	t.Helper()
+
+	ks, err := NewKeySet(signingKID, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func validClaims() testClaims {
+	now := time.Now()
+
+	return testClaims{
+		RegisteredClaims: RegisteredClaims{
+			ID:        "jti",
+			Subject:   "42",
+			IssuedAt:  NumericDate(now),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+		Role: "Admin",
+	}
+}
+
+// forge builds a token with the given header and claims, signed with key.
+func forge(t *testing.T, key *Key, h header, claims interface{}) string {
+	t.Helper()
+
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := encoding.EncodeToString(rawHeader) + "." + encoding.EncodeToString(payload)
+	return signingInput + "." + encoding.EncodeToString(key.sign([]byte(signingInput)))
+}
+
+func TestSignVerify(t *testing.T) {
+	hs := mustHS256Key(t, "hs")
+	ed := mustEd25519Key(t, "ed")
+
+	tests := []struct {
+		name    string
+		signer  *KeySet
+		claims  func(*testClaims)
+		wantErr error
+	}{
+		{name: "HS256", signer: mustKeySet(t, "hs", hs)},
+		{name: "EdDSA", signer: mustKeySet(t, "ed", ed)},
+		{
+			name:    "expired",
+			signer:  mustKeySet(t, "hs", hs),
+			claims:  func(c *testClaims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() },
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:   "expired within the leeway",
+			signer: mustKeySet(t, "hs", hs),
+			claims: func(c *testClaims) { c.ExpiresAt = time.Now().Add(-leeway / 2).Unix() },
+		},
+		{
+			name:    "without expiry",
+			signer:  mustKeySet(t, "hs", hs),
+			claims:  func(c *testClaims) { c.ExpiresAt = 0 },
+			wantErr: ErrExpiredToken,
+		},
+		{
+			name:    "not yet valid",
+			signer:  mustKeySet(t, "hs", hs),
+			claims:  func(c *testClaims) { c.NotBefore = time.Now().Add(time.Hour).Unix() },
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "unknown kid",
+			signer:  mustKeySet(t, "other", mustHS256Key(t, "other")),
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name:    "same kid, different secret",
+			signer:  mustKeySet(t, "hs", &Key{ID: "hs", Algorithm: AlgHS256, secret: bytes.Repeat([]byte("x"), 32)}),
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	verifier := mustKeySet(t, "hs", hs, ed)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			if tt.claims != nil {
+				tt.claims(&claims)
+			}
+
+			token, err := tt.signer.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got testClaims
+			err = verifier.Verify(token, &got)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != claims {
+				t.Errorf("claims = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsForgedTokens(t *testing.T) {
+	hs := mustHS256Key(t, "hs")
+	ed := mustEd25519Key(t, "ed")
+	ks := mustKeySet(t, "ed", hs, ed)
+
+	// An HS256 key made from the public half of the EdDSA key, as an
+	// attacker who knows the public key could.
+	confused := &Key{ID: "ed", Algorithm: AlgHS256, secret: ed.publicKey}
+
+	valid, err := ks.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+
+	tampered := validClaims()
+	tampered.Role = "Customer"
+	rawTampered, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "algorithm of another key", token: forge(t, confused, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "ed"}, validClaims())},
+		{name: "none algorithm", token: encoding.EncodeToString([]byte(`{"alg":"none","kid":"ed"}`)) + "." + parts[1] + "."},
+		{name: "tampered payload", token: parts[0] + "." + encoding.EncodeToString(rawTampered) + "." + parts[2]},
+		{name: "missing signature", token: parts[0] + "." + parts[1] + "."},
+		{name: "two segments", token: parts[0] + "." + parts[1]},
+		{name: "header not base64", token: "!!." + parts[1] + "." + parts[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims testClaims
+			err := ks.Verify(tt.token, &claims)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("err = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := mustHS256Key(t, "old")
+	current := mustEd25519Key(t, "new")
+
+	token, err := mustKeySet(t, "old", old).Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the rotation tokens signed with the old key keep working until
+	// they expire.
+	var claims testClaims
+	err = mustKeySet(t, "new", old, current).Verify(token, &claims)
+	if err != nil {
+		t.Fatalf("token of the previous key: %v", err)
+	}
+
+	// Once the old key is dropped they don't.
+	err = mustKeySet(t, "new", current).Verify(token, &claims)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	ed := mustEd25519Key(t, "ed")
+	public, err := NewEd25519PublicKey("public", ed.publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		signingKID string
+		keys       []*Key
+	}{
+		{name: "missing signing key", signingKID: "missing", keys: []*Key{ed}},
+		{name: "public signing key", signingKID: "public", keys: []*Key{ed, public}},
+		{name: "duplicate kid", signingKID: "ed", keys: []*Key{ed, mustEd25519Key(t, "ed")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeySet(tt.signingKID, tt.keys...)
+			if err == nil {
+				t.Fatal("err = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("s"), 32))
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("e"), ed25519.SeedSize))
+
+	keys, err := ParseKeys("a:HS256:" + secret + ", b:EdDSA:" + seed + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "a" || keys[0].Algorithm != AlgHS256 || keys[1].ID != "b" || keys[1].Algorithm != AlgEdDSA {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	for _, spec := range []string{
+		"a:HS256",
+		"a:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"a:RS256:" + secret,
+		"a:EdDSA:" + secret + "x",
+		"a:EdDSA-public:" + secret + secret,
+	} {
+		_, err := ParseKeys(spec)
+		if err == nil {
+			t.Errorf("ParseKeys(%q): err = nil, want an error", spec)
+		}
+	}
+}
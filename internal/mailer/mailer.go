@@ -0,0 +1,121 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer delivers a transactional email, built from the named template and
+// its dynamic data, to a single recipient.
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+type Message struct {
+	Recipient string      `json:"recipient"`
+	Template  string      `json:"template"`
+	Subject   string      `json:"subject"`
+	PlainBody string      `json:"plain_body"`
+	HTMLBody  string      `json:"html_body"`
+	Data      interface{} `json:"data"`
+	SentAt    time.Time   `json:"sent_at"`
+}
+
+// render executes the "subject", "plainBody" and "htmlBody" templates defined
+// in templateFile. The HTML body goes through html/template so that dynamic
+// data is escaped, the other two parts are rendered as plain text.
+func render(recipient, templateFile string, data interface{}) (*Message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Recipient: recipient,
+		Template:  templateFile,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Data:      data,
+	}, nil
+}
+
+// Sink is a Mailer which never talks to a mail server. Every message is rendered
+// and kept in memory and, if out is not nil, written to it as a JSON line. It is
+// meant for development and tests.
+type Sink struct {
+	out      io.Writer
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewSink(out io.Writer) *Sink {
+	return &Sink{out: out}
+}
+
+func (s *Sink) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	msg.SentAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, *msg)
+
+	if s.out == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
+
+// Messages returns a copy of every message the sink has received so far.
+func (s *Sink) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// SMTP is a Mailer which delivers messages through an SMTP server.
+type SMTP struct {
+	addr     string
+	host     string
+	auth     smtp.Auth
+	sender   string
+	attempts int
+	backoff  time.Duration
+}
+
+func NewSMTP(host string, port int, username, password, sender string) *SMTP {
+	m := &SMTP{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		sender:   sender,
+		attempts: 3,
+		backoff:  500 * time.Millisecond,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+func (m *SMTP) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := m.build(msg)
+	if err != nil {
+		return err
+	}
+
+	from, err := mailAddress(m.sender)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= m.attempts; i++ {
+		err = smtp.SendMail(m.addr, m.auth, from, []string{recipient}, body)
+		if err == nil || !isTransient(err) {
+			return err
+		}
+
+		if i < m.attempts {
+			time.Sleep(time.Duration(i) * m.backoff)
+		}
+	}
+
+	return fmt.Errorf("mailer: giving up after %d attempts: %w", m.attempts, err)
+}
+
+// build assembles a multipart/alternative message carrying both the plain text
+// and the HTML body.
+func (m *SMTP) build(msg *Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", m.sender)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = pw.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mailAddress extracts the bare address from a sender such as
+// "Kaspi <no-reply@kaspi.kz>", which is what the SMTP envelope expects.
+func mailAddress(sender string) (string, error) {
+	addr, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
+
+// isTransient reports whether a failed delivery is worth retrying: network
+// errors and 4xx replies from the server are, permanent 5xx replies are not.
+func isTransient(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testProvider is an identity provider serving discovery, the JWKS and the
+// token endpoint. The token endpoint answers with the ID token in idToken when
+// the code verifier matches the challenge of the last authorization URL.
+type testProvider struct {
+	*httptest.Server
+
+	key       *rsa.PrivateKey
+	challenge string
+	idToken   string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 tp.URL,
+			"authorization_endpoint": tp.URL + "/authorize",
+			"token_endpoint":         tp.URL + "/token",
+			"jwks_uri":               tp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   encoding.EncodeToString(key.N.Bytes()),
+				"e":   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "code" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		if Challenge(r.PostFormValue("code_verifier")) != tp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": tp.idToken})
+	})
+
+	tp.Server = httptest.NewServer(mux)
+	t.Cleanup(tp.Close)
+
+	return tp
+}
+
+// sign returns an RS256 ID token with the given claims.
+func (tp *testProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + encoding.EncodeToString(signature)
+}
+
+func (tp *testProvider) claims() map[string]interface{} {
+	now := time.Now()
+
+	return map[string]interface{}{
+		"iss":            tp.URL,
+		"sub":            "1234",
+		"aud":            "client",
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          "nonce",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tp := newTestProvider(t)
+
+	p := NewProvider(Config{
+		Name:        "test",
+		Issuer:      tp.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"openid", "email"},
+	})
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/authorize" {
+		t.Errorf("authorization path = %q, want /authorize", u.Path)
+	}
+
+	q := u.Query()
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        Challenge(verifier),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	tp.challenge = q.Get("code_challenge")
+
+	tests := []struct {
+		name     string
+		claims   func(map[string]interface{})
+		verifier string
+		nonce    string
+		wantErr  error
+	}{
+		{name: "valid", verifier: verifier, nonce: "nonce"},
+		{name: "wrong verifier", verifier: "wrong", nonce: "nonce", wantErr: ErrExchange},
+		{name: "wrong nonce", verifier: verifier, nonce: "other", wantErr: ErrInvalidToken},
+		{
+			name:     "wrong audience",
+			claims:   func(c map[string]interface{}) { c["aud"] = []string{"other"} },
+			verifier: verifier,
+			nonce:    "nonce",
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:     "wrong issuer",
+			claims:   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+			verifier: verifier,
+			nonce:    "nonce",
+			wantErr:  ErrInvalidToken,
+		},
+		{
+			name:     "expired",
+			claims:   func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			verifier: verifier,
+			nonce:    "nonce",
+			wantErr:  ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := tp.claims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			tp.idToken = tp.sign(t, claims)
+
+			token, err := p.Exchange(context.Background(), "code", tt.verifier, tt.nonce)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token.Subject != "1234" || token.Email != "alice@example.com" || !token.EmailVerified {
+				t.Errorf("unexpected token %+v", token)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedSignature(t *testing.T) {
+	tp := newTestProvider(t)
+
+	p := NewProvider(Config{Name: "test", Issuer: tp.URL, ClientID: "client"})
+
+	raw := tp.sign(t, tp.claims())
+	raw = raw[:len(raw)-4] + "AAAA"
+
+	_, err := p.Verify(context.Background(), raw, "nonce")
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("err = %v, want %v", err, ErrInvalidToken)
+	}
+}
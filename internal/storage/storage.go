@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// KeyRX matches the keys objects can be stored under: slash separated
+// segments of letters, digits, dots, hyphens and underscores, none of them
+// starting with a dot, so keys can't climb out of a directory.
+var KeyRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`)
+
+// Storage keeps blobs, like uploaded images, under keys.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the content of the object and its content type. The
+	// caller closes the content.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,156 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCode checks the RFC 6238 test vectors for SHA1, truncated to the six
+// digits authenticator apps show.
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+
+	// Secrets are accepted in lower case too, as some users type them.
+	got, err := Code(strings.ToLower(rfcSecret), Counter(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("Code with a lower case secret = %q, %v, want %q", got, err, "287082")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Counter(now)
+
+	code := func(counter int64) string {
+		c, err := Code(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		skew        int64
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current step", code: code(current), skew: 1, wantCounter: current, wantOK: true},
+		{name: "previous step within the window", code: code(current - 1), skew: 1, wantCounter: current - 1, wantOK: true},
+		{name: "next step within the window", code: code(current + 1), skew: 1, wantCounter: current + 1, wantOK: true},
+		{name: "two steps back", code: code(current - 2), skew: 1},
+		{name: "two steps ahead", code: code(current + 2), skew: 1},
+		{name: "previous step without skew", code: code(current - 1), skew: 0},
+		{name: "wrong code", code: "000000", skew: 1},
+		{name: "too short", code: code(current)[:5], skew: 1},
+		{name: "too long", code: code(current) + "0", skew: 1},
+		{name: "empty", code: "", skew: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Errorf("Validate = %d, %v, want %d, %v", counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestValidateCounterReplay checks that the counters Validate returns let
+// callers reject a code which has been used before: the same code, or the code
+// of an earlier step still inside the window, never has a greater counter than
+// the last one used.
+func TestValidateCounterReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	code, err := Code(rfcSecret, Counter(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, ok := Validate(rfcSecret, code, now, 1)
+	if !ok {
+		t.Fatal("current code rejected")
+	}
+
+	// The same code a few seconds later, still in the same step.
+	again, ok := Validate(rfcSecret, code, now.Add(Period/2*time.Second), 1)
+	if !ok || again > last {
+		t.Errorf("replayed code: counter %d, ok %v, want a counter not above %d", again, ok, last)
+	}
+
+	// The code of the previous step, accepted by the window one step later.
+	previous, err := Code(rfcSecret, last-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlier, ok := Validate(rfcSecret, previous, now.Add(Period*time.Second), 1)
+	if ok && earlier > last {
+		t.Errorf("code of an earlier step: counter %d, want one not above %d", earlier, last)
+	}
+
+	// The next code is newer and accepted.
+	next, err := Code(rfcSecret, last+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, ok := Validate(rfcSecret, next, now.Add(Period*time.Second), 1)
+	if !ok || newer <= last {
+		t.Errorf("next code: counter %d, ok %v, want a counter above %d", newer, ok, last)
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != secretSize {
+		t.Fatalf("secret %q decodes to %d bytes, %v, want %d bytes", secret, len(key), err, secretSize)
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("two secrets are the same")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Ecom Shop", "alice@example.com", rfcSecret)
+	want := "otpauth://totp/Ecom%20Shop:alice@example.com?algorithm=SHA1&digits=6&issuer=Ecom+Shop&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("URI = %q, want %q", got, want)
+	}
+}
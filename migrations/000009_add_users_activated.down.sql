@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN IF EXISTS activated;
\ No newline at end of file
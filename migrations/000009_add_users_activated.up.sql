@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated bool NOT NULL DEFAULT false;
+
+-- Accounts which existed before activation was required stay usable.
+UPDATE users SET activated = true;
\ No newline at end of file
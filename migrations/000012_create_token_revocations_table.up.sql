@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS token_revocations (
+    id bigserial PRIMARY KEY,
+    jti text UNIQUE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    revoked_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS token_revocations_expiry_idx ON token_revocations (expiry);
\ No newline at end of file
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('products:write'),
+    ('products:manage'),
+    ('comments:write'),
+    ('carts:write'),
+    ('orders:write'),
+    ('orders:approve'),
+    ('users:manage')
+ON CONFLICT (code) DO NOTHING;
+
+-- Existing users get the default grants of their role (0 Admin, 1 Client, 2 Customer).
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id
+FROM users, permissions
+WHERE permissions.code IN ('comments:write', 'carts:write', 'orders:write')
+OR (users.role IN (0, 1) AND permissions.code IN ('products:write', 'orders:approve'))
+OR (users.role = 0 AND permissions.code IN ('products:manage', 'users:manage'))
+ON CONFLICT DO NOTHING;
\ No newline at end of file
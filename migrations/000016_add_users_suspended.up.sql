@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended bool NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
\ No newline at end of file
@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE code = 'categories:manage';
+DROP INDEX IF EXISTS products_category_id_idx;
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_category_id_fkey;
+ALTER TABLE products ADD CONSTRAINT products_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories ON DELETE CASCADE;
+DROP INDEX IF EXISTS categories_parent_id_idx;
+DROP INDEX IF EXISTS categories_slug_idx;
+ALTER TABLE categories DROP COLUMN IF EXISTS version;
+ALTER TABLE categories DROP COLUMN IF EXISTS created_at;
+ALTER TABLE categories DROP COLUMN IF EXISTS slug;
+ALTER TABLE categories DROP COLUMN IF EXISTS parent_id;
\ No newline at end of file
@@ -0,0 +1,31 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES categories ON DELETE RESTRICT;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS slug text;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+UPDATE categories
+SET slug = coalesce(nullif(trim(both '-' from lower(regexp_replace(title, '[^[:alnum:]]+', '-', 'g'))), ''), 'category')
+WHERE slug IS NULL;
+
+UPDATE categories c
+SET slug = c.slug || '-' || c.id
+WHERE EXISTS (SELECT 1 FROM categories o WHERE o.slug = c.slug AND o.id < c.id);
+
+ALTER TABLE categories ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS categories_slug_idx ON categories (slug);
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+-- Deleting a category must not take its products with it.
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_category_id_fkey;
+ALTER TABLE products ADD CONSTRAINT products_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS products_category_id_idx ON products (category_id);
+
+INSERT INTO permissions (code)
+VALUES ('categories:manage')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id
+FROM users, permissions
+WHERE users.role = 0 AND permissions.code = 'categories:manage'
+ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS product_variants (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    sku text NOT NULL,
+    options jsonb NOT NULL DEFAULT '{}',
+    price bigint NOT NULL,
+    stock int NOT NULL DEFAULT 0,
+    images text[],
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS product_variants_sku_idx ON product_variants (sku);
+CREATE INDEX IF NOT EXISTS product_variants_product_id_idx ON product_variants (product_id);
+
+-- Every existing product becomes its own single variant.
+INSERT INTO product_variants (product_id, sku, price, stock, images)
+SELECT id, 'P-' || id, price, stock, images
+FROM products;
+
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants ON DELETE CASCADE;
+
+UPDATE carts
+SET variant_id = (SELECT min(id) FROM product_variants WHERE product_variants.product_id = carts.product_id)
+WHERE variant_id IS NULL;
+
+ALTER TABLE carts ALTER COLUMN variant_id SET NOT NULL;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS variant_id bigint REFERENCES product_variants ON DELETE RESTRICT;
+
+UPDATE orders
+SET variant_id = carts.variant_id
+FROM carts
+WHERE carts.id = orders.cart_id AND orders.variant_id IS NULL;
+
+ALTER TABLE orders ALTER COLUMN variant_id SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS attributes (
+    id bigserial PRIMARY KEY,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE CASCADE,
+    code text NOT NULL,
+    name text NOT NULL,
+    type text NOT NULL CHECK (type IN ('string', 'number', 'boolean', 'enum')),
+    options text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (category_id, code)
+);
+
+CREATE INDEX IF NOT EXISTS attributes_code_idx ON attributes (code);
+
+-- Every value is kept as text for equality filters and facets; numbers are
+-- also kept as numeric for range filters.
+CREATE TABLE IF NOT EXISTS product_attributes (
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    attribute_id bigint NOT NULL REFERENCES attributes ON DELETE CASCADE,
+    value_text text NOT NULL,
+    value_number numeric,
+    PRIMARY KEY (product_id, attribute_id)
+);
+
+CREATE INDEX IF NOT EXISTS product_attributes_text_idx ON product_attributes (attribute_id, value_text);
+CREATE INDEX IF NOT EXISTS product_attributes_number_idx ON product_attributes (attribute_id, value_number);
\ No newline at end of file
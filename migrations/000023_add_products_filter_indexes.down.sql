@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS products_created_at_idx;
+DROP INDEX IF EXISTS products_user_id_idx;
+DROP INDEX IF EXISTS products_rating_idx;
+DROP INDEX IF EXISTS products_in_stock_idx;
+DROP INDEX IF EXISTS products_price_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS products_price_idx ON products (price);
+CREATE INDEX IF NOT EXISTS products_in_stock_idx ON products (id) WHERE stock > 0;
+CREATE INDEX IF NOT EXISTS products_rating_idx ON products (rating);
+CREATE INDEX IF NOT EXISTS products_user_id_idx ON products (user_id);
+CREATE INDEX IF NOT EXISTS products_created_at_idx ON products (created_at);
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS products_title_trgm_idx;
+DROP INDEX IF EXISTS products_search_idx;
+CREATE INDEX IF NOT EXISTS products_title_idx ON products USING GIN (to_tsvector('simple', title));
+DROP TRIGGER IF EXISTS products_search_update ON products;
+DROP FUNCTION IF EXISTS products_search_update();
+ALTER TABLE products DROP COLUMN IF EXISTS search;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS products_search;
\ No newline at end of file
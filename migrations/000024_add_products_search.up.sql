@@ -0,0 +1,33 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- PostgreSQL ships no Kazakh stemmer, so Kazakh words go through the Russian
+-- one, which only partly handles their endings. The trigram index on titles
+-- covers the rest, along with typos.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'products_search') THEN
+        CREATE TEXT SEARCH CONFIGURATION products_search (COPY = pg_catalog.russian);
+    END IF;
+END
+$$;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search tsvector;
+
+CREATE OR REPLACE FUNCTION products_search_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search := setweight(to_tsvector('products_search', coalesce(NEW.title, '')), 'A') ||
+                  setweight(to_tsvector('products_search', coalesce(NEW.description, '')), 'B');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_search_update ON products;
+CREATE TRIGGER products_search_update BEFORE INSERT OR UPDATE OF title, description ON products
+    FOR EACH ROW EXECUTE PROCEDURE products_search_update();
+
+UPDATE products SET search = setweight(to_tsvector('products_search', coalesce(title, '')), 'A') ||
+                             setweight(to_tsvector('products_search', coalesce(description, '')), 'B');
+
+DROP INDEX IF EXISTS products_title_idx;
+CREATE INDEX IF NOT EXISTS products_search_idx ON products USING GIN (search);
+CREATE INDEX IF NOT EXISTS products_title_trgm_idx ON products USING GIN (title gin_trgm_ops);
\ No newline at end of file
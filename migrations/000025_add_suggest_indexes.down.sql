@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS categories_title_trgm_idx;
+DROP INDEX IF EXISTS categories_title_prefix_idx;
+DROP INDEX IF EXISTS products_title_prefix_idx;
\ No newline at end of file
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS products_title_prefix_idx ON products (lower(title) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS categories_title_prefix_idx ON categories (lower(title) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS categories_title_trgm_idx ON categories USING GIN (title gin_trgm_ops);
\ No newline at end of file
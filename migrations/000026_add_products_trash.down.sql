@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS carts_product_id_idx;
+DROP INDEX IF EXISTS products_deleted_at_idx;
+
+ALTER TABLE products ALTER COLUMN deleted_at TYPE timestamp(0);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE products ALTER COLUMN deleted_at TYPE timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS carts_product_id_idx ON carts (product_id);
\ No newline at end of file